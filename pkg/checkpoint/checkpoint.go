@@ -0,0 +1,260 @@
+// Package checkpoint saves the progress of a sync, to resume it if interrupted.
+// The saved SCAN cursor of a node is never ahead of keys not yet confirmed
+// by the writer, so resuming offers the same guarantees of a single SCAN.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Node is the progress of the SCAN of a source node.
+// Cursor is the SCAN cursor to resume from, Done marks a completed SCAN.
+type Node struct {
+	Cursor uint64 `json:"cursor"`
+	Done   bool   `json:"done"`
+}
+
+// State is the progress saved to the checkpoint file.
+// Source is the source URI without credentials.
+// Nodes maps node addresses, "" for single node sources, to their progress.
+// Confirmed counts the keys confirmed by the writer.
+type State struct {
+	Source    string           `json:"source"`
+	Nodes     map[string]*Node `json:"nodes"`
+	Confirmed int64            `json:"confirmed"`
+	Updated   time.Time        `json:"updated"`
+}
+
+// Page is a page of scanned keys.
+// It completes once closed and all its keys have been confirmed,
+// never if one of its keys failed to be read.
+type Page struct {
+	cp      *Checkpoint
+	next    uint64
+	pending int
+	closed  bool
+	failed  bool
+}
+
+// Checkpoint tracks the progress of a sync, and saves it to Path.
+// A nil Checkpoint tracks nothing.
+type Checkpoint struct {
+	Path string
+
+	mu    sync.Mutex
+	state State
+	// pages lists the open pages of each node, in SCAN order.
+	pages map[string][]*Page
+	// keys maps keys on their way to the writer to their pages.
+	keys map[string][]*Page
+}
+
+// New creates a Checkpoint for a new sync of source.
+func New(path, source string) *Checkpoint {
+	return &Checkpoint{
+		Path: path,
+		state: State{
+			Source: source,
+			Nodes:  map[string]*Node{},
+		},
+		pages: map[string][]*Page{},
+		keys:  map[string][]*Page{},
+	}
+}
+
+// Load loads a Checkpoint saved by a previous sync of source.
+func Load(path, source string) (*Checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := New(path, source)
+	if err := json.Unmarshal(b, &cp.state); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %s", path, err)
+	}
+	if cp.state.Source != source {
+		return nil, fmt.Errorf("checkpoint %s is for source %s", path, cp.state.Source)
+	}
+	if cp.state.Nodes == nil {
+		cp.state.Nodes = map[string]*Node{}
+	}
+
+	return cp, nil
+}
+
+// Cursor returns the SCAN cursor to start a node from,
+// done is true if the node was completely scanned.
+func (cp *Checkpoint) Cursor(node string) (cursor uint64, done bool) {
+	if cp == nil {
+		return 0, false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	n, ok := cp.state.Nodes[node]
+	if !ok {
+		return 0, false
+	}
+	return n.Cursor, n.Done
+}
+
+// Confirmed returns the count of keys confirmed by the writer.
+func (cp *Checkpoint) Confirmed() int64 {
+	if cp == nil {
+		return 0
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.state.Confirmed
+}
+
+// Add adds a page scanned from node, next being the cursor returned by SCAN.
+// Pages of a node must be added in SCAN order.
+func (cp *Checkpoint) Add(node string, next uint64) *Page {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if _, ok := cp.state.Nodes[node]; !ok {
+		cp.state.Nodes[node] = &Node{}
+	}
+	p := &Page{cp: cp, next: next}
+	cp.pages[node] = append(cp.pages[node], p)
+
+	return p
+}
+
+// Emit records a key of the page sent to the writer.
+func (p *Page) Emit(key string) {
+	if p == nil {
+		return
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+
+	p.pending++
+	p.cp.keys[key] = append(p.cp.keys[key], p)
+}
+
+// Fail records a key of the page which failed to be read: the cursor of
+// its node never moves past the page, to read it again when resuming.
+func (p *Page) Fail() {
+	if p == nil {
+		return
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+
+	p.failed = true
+}
+
+// Close marks the end of the keys of the page.
+func (p *Page) Close() {
+	if p == nil {
+		return
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+
+	p.closed = true
+	p.cp.advance()
+}
+
+// Confirm records keys written by the writer.
+func (cp *Checkpoint) Confirm(keys ...string) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, key := range keys {
+		cp.state.Confirmed++
+
+		pages := cp.keys[key]
+		if len(pages) == 0 {
+			continue
+		}
+		pages[0].pending--
+		if len(pages) == 1 {
+			delete(cp.keys, key)
+		} else {
+			cp.keys[key] = pages[1:]
+		}
+	}
+
+	cp.advance()
+}
+
+// advance moves node cursors past their completed pages.
+// A SCAN returning cursor 0 completes the node.
+func (cp *Checkpoint) advance() {
+	for node, pages := range cp.pages {
+		for len(pages) > 0 && pages[0].closed && pages[0].pending == 0 && !pages[0].failed {
+			cp.state.Nodes[node].Cursor = pages[0].next
+			cp.state.Nodes[node].Done = pages[0].next == 0
+			pages = pages[1:]
+		}
+		cp.pages[node] = pages
+	}
+}
+
+// Save writes the current State to Path, replacing it atomically.
+func (cp *Checkpoint) Save() error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	cp.state.Updated = time.Now()
+	b, err := json.MarshalIndent(cp.state, "", "  ")
+	cp.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := cp.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.Path)
+}
+
+// Remove deletes the checkpoint file, once the sync completed.
+func (cp *Checkpoint) Remove() error {
+	if cp == nil {
+		return nil
+	}
+	err := os.Remove(cp.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Run saves the Checkpoint every interval until ctx is done.
+// To be used in an ErrGroup.
+func (cp *Checkpoint) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if err := cp.Save(); err != nil {
+				return err
+			}
+		}
+	}
+}
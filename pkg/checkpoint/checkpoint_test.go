@@ -0,0 +1,109 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorWaitsForConfirmedKeys(t *testing.T) {
+	cp := New("", "redis://s/0")
+
+	// Two pages dumped out of order.
+	p1 := cp.Add("", 10)
+	p2 := cp.Add("", 20)
+	p2.Emit("c")
+	p2.Close()
+	p1.Emit("a")
+	p1.Emit("b")
+	p1.Close()
+
+	if cursor, _ := cp.Cursor(""); cursor != 0 {
+		t.Errorf("cursor should wait for page 1, got %d", cursor)
+	}
+
+	// Page 2 confirmed first, page 1 still pending.
+	cp.Confirm("c", "a")
+	if cursor, _ := cp.Cursor(""); cursor != 0 {
+		t.Errorf("cursor should wait for page 1, got %d", cursor)
+	}
+
+	cp.Confirm("b")
+	if cursor, done := cp.Cursor(""); cursor != 20 || done {
+		t.Errorf("cursor should move past page 2, got %d", cursor)
+	}
+
+	if cp.Confirmed() != 3 {
+		t.Errorf("expected 3 confirmed keys, got %d", cp.Confirmed())
+	}
+
+	// Last page, SCAN returned cursor 0.
+	p3 := cp.Add("", 0)
+	p3.Close()
+	if _, done := cp.Cursor(""); !done {
+		t.Error("node should be done")
+	}
+}
+
+func TestCursorStopsAtFailedPage(t *testing.T) {
+	cp := New("", "redis://s/0")
+
+	p1 := cp.Add("", 10)
+	p1.Emit("a")
+	p1.Fail()
+	p1.Close()
+	p2 := cp.Add("", 0)
+	p2.Close()
+	cp.Confirm("a")
+
+	if cursor, done := cp.Cursor(""); cursor != 0 || done {
+		t.Errorf("cursor shouldn't move past a failed page, got %d", cursor)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "rump.checkpoint")
+	defer os.Remove(path)
+
+	cp := New(path, "redis://s/0")
+	p := cp.Add("10.0.0.1:6379", 42)
+	p.Emit("a")
+	p.Close()
+	cp.Confirm("a")
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path, "redis://other/0"); err == nil {
+		t.Error("checkpoint of another source should not load")
+	}
+
+	loaded, err := Load(path, "redis://s/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor, done := loaded.Cursor("10.0.0.1:6379"); cursor != 42 || done {
+		t.Errorf("expected cursor 42, got %d", cursor)
+	}
+	if loaded.Confirmed() != 1 {
+		t.Errorf("expected 1 confirmed key, got %d", loaded.Confirmed())
+	}
+
+	if err := loaded.Remove(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("checkpoint should be removed")
+	}
+}
+
+func TestNil(t *testing.T) {
+	var cp *Checkpoint
+	p := cp.Add("", 1)
+	p.Emit("a")
+	p.Close()
+	cp.Confirm("a")
+	if err := cp.Save(); err != nil {
+		t.Error(err)
+	}
+}
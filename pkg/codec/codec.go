@@ -0,0 +1,88 @@
+// Package codec compresses and decompresses file streams.
+// Codecs are registered by name, and detected from their magic bytes.
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Codec compresses a stream, starting with Magic once compressed.
+// Extension is the file name extension of compressed files.
+type Codec interface {
+	Name() string
+	Extension() string
+	Magic() []byte
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = map[string]Codec{}
+)
+
+// Register makes a Codec available by name, replacing any with that name.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Get returns the Codec registered with name.
+func Get(name string) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// Names returns the names of the registered Codecs, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var names []string
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForPath returns the Codec whose extension ends path, nil if none does.
+func ForPath(path string) Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, c := range codecs {
+		if strings.HasSuffix(path, c.Extension()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Detect returns the Codec whose magic starts r, nil if none does.
+// Nothing is consumed from r.
+func Detect(r *bufio.Reader) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, c := range codecs {
+		magic := c.Magic()
+		b, err := r.Peek(len(magic))
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if bytes.Equal(b, magic) {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
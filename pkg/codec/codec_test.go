@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGzip(t *testing.T) {
+	c, err := Get("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := c.NewWriter(&buf)
+	w.Write([]byte("rump"))
+	w.Close()
+
+	r := bufio.NewReader(&buf)
+	if d, err := Detect(r); err != nil || d != c {
+		t.Fatalf("gzip not detected: %v", err)
+	}
+	rc, err := c.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(rc); string(b) != "rump" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	if d, _ := Detect(bufio.NewReader(bytes.NewReader([]byte("r")))); d != nil {
+		t.Error("plain content detected as compressed")
+	}
+	if ForPath("/tmp/dump.rump.gz") != c || ForPath("/tmp/dump.rump") != nil {
+		t.Error("wrong codec for path")
+	}
+	if _, err := Get("lz4"); err == nil {
+		t.Error("unknown codecs should not be found")
+	}
+}
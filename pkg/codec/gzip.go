@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+func init() {
+	Register(Gzip{})
+}
+
+// Gzip is the gzip Codec.
+type Gzip struct{}
+
+// Name returns "gzip".
+func (Gzip) Name() string {
+	return "gzip"
+}
+
+// Extension returns ".gz".
+func (Gzip) Extension() string {
+	return ".gz"
+}
+
+// Magic returns the gzip header magic bytes.
+func (Gzip) Magic() []byte {
+	return []byte{0x1f, 0x8b}
+}
+
+// NewWriter returns a gzip writer of the default compression level.
+func (Gzip) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// NewReader returns a gzip reader.
+func (Gzip) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
@@ -4,58 +4,341 @@ package config
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/domwong/rump/pkg/codec"
+	"github.com/domwong/rump/pkg/file"
+	"github.com/domwong/rump/pkg/filter"
+	"github.com/domwong/rump/pkg/merge"
+	"github.com/domwong/rump/pkg/rename"
+	"github.com/domwong/rump/pkg/transform"
 )
 
 // Resource can be either Redis (isRedis) or file.
 // URI is either a Redis URI or a file path.
+// IsCluster marks a Redis Cluster URI, seed nodes separated by commas.
+// IsSentinel marks a Redis Sentinel URI, sentinels separated by commas,
+// followed by the master name and DB: redis+sentinel://host:26379/mymaster/0.
+// IsPSync marks a Redis source read as a replica: redis+psync://host:6379/0.
+// Format is the file format, detected from the file extension.
+// Codec is the file compression codec, detected from a last extension
+// like .gz, preceded by the format one.
+// IsStdio marks the - URI, stdin as source and stdout as target.
 type Resource struct {
-	URI     string
-	IsRedis bool
+	URI        string
+	IsRedis    bool
+	IsCluster  bool
+	IsSentinel bool
+	IsPSync    bool
+	IsStdio    bool
+	Format     string
+	Codec      string
+}
+
+// DB maps a Source database to a Target database.
+type DB struct {
+	Source int
+	Target int
 }
 
 // Config represents the current source and target config.
 // Source and target are Resources.
+// Targets are more targets written from the same read of the source.
+// TargetAbort aborts the sync when one of several targets fails, instead
+// of completing the others and reporting the failures at the end.
+// Sources are more sources merged with Source, the conflicts of keys read
+// from several sources resolved with the Conflict merge policy.
 // Silent disables verbose mode.
 // TTL enables keys TTL sync.
+// AbsTTL restores TTLs as absolute expire times, implies TTL.
+// Filter selects the source keys to sync.
+// ReadBatch is the number of keys per Redis source pipeline.
+// ReadDepth is the number of Redis source pipelines in flight.
+// Writers is the number of Redis target goroutines restoring keys.
+// WriteBatch is the number of keys per Redis target pipeline.
+// Checkpoint is the file where the sync progress is saved every
+// CheckpointInterval, Resume resumes the sync saved to it.
+// Verify compares the source with the Redis target instead of syncing,
+// writing differences to VerifyReport, expire times within TTLWindow.
+// Mirror deletes the Redis target keys absent from the source, after a
+// complete sync, up to MirrorLimit keys (0 for no limit).
+// MirrorDryRun lists them instead, implies Mirror.
+// Follow keeps syncing the Redis source changes after the initial sync,
+// until interrupted.
+// RDBDB is the database of RDB file sources and targets.
+// Logical copies Redis source values with type-specific commands,
+// rebuilt with native commands, instead of DUMP and RESTORE.
+// EncryptionKey encrypts file targets and decrypts file sources.
+// Transform masks the values of the keys matching its rules, before
+// writing them to the target.
+// Rename rewrites the keys written to the target, with rules applied in
+// order. RenameAbort aborts the sync when two source keys are renamed to
+// the same target key, instead of reporting it.
 type Config struct {
-	Source Resource
-	Target Resource
-	Silent bool
-	TTL    bool
+	Source     Resource
+	Target     Resource
+	Silent     bool
+	TTL        bool
+	AbsTTL     bool
+	Filter     filter.Filter
+	ReadBatch  int
+	ReadDepth  int
+	Writers    int
+	WriteBatch int
+
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             bool
+
+	Verify       bool
+	VerifyReport string
+	TTLWindow    time.Duration
+
+	Mirror       bool
+	MirrorDryRun bool
+	MirrorLimit  int
+
+	Follow bool
+
+	RDBDB int
+
+	Logical bool
+
+	EncryptionKey []byte
+
+	Transform *transform.Rules
+
+	Rename      []rename.Rule
+	RenameAbort bool
+
+	AllDBs bool
+	DBs    []DB
+
+	Targets     []Resource
+	TargetAbort bool
+
+	Sources  []Resource
+	Conflict string
+}
+
+// AllSources returns Source followed by the other Sources.
+func (cfg Config) AllSources() []Resource {
+	return append([]Resource{cfg.Source}, cfg.Sources...)
+}
+
+// fileSource reports whether one of the sources is a file.
+func (cfg Config) fileSource() bool {
+	for _, res := range cfg.AllSources() {
+		if !res.IsRedis {
+			return true
+		}
+	}
+	return false
+}
+
+// AllTargets returns Target followed by the other Targets.
+func (cfg Config) AllTargets() []Resource {
+	return append([]Resource{cfg.Target}, cfg.Targets...)
+}
+
+// fileTarget reports whether one of the targets is a file.
+func (cfg Config) fileTarget() bool {
+	for _, res := range cfg.AllTargets() {
+		if !res.IsRedis {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiDB reports whether several databases are synced.
+func (cfg Config) MultiDB() bool {
+	return cfg.AllDBs || len(cfg.DBs) > 0
+}
+
+// TargetDB returns the target database of a source database,
+// false if it isn't synced.
+func (cfg Config) TargetDB(db int) (int, bool) {
+	if cfg.AllDBs {
+		return db, true
+	}
+	for _, d := range cfg.DBs {
+		if d.Source == db {
+			return d.Target, true
+		}
+	}
+	return 0, false
 }
 
-// exit will exit and print the usage.
+// list is a repeatable string flag.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// types lists the Redis types supported by the type filter.
+var types = []string{"string", "list", "set", "zset", "hash", "stream"}
+
+// exit will exit and print the error and usage to stderr,
+// never mixed with data streamed to stdout.
 // Used in case of errors during flags parse/validate.
 func exit(e error) {
-	fmt.Println(e)
+	fmt.Fprintln(os.Stderr, e)
 	flag.PrintDefaults()
 	os.Exit(1)
 }
 
-// validate makes sure from and to are Redis URIs or file paths,
+// resource detects the Resource type from its URI.
+func resource(uri string) Resource {
+	res := Resource{
+		URI: uri,
+	}
+
+	path := uri
+	c := codec.ForPath(uri)
+	if c != nil {
+		path = strings.TrimSuffix(uri, c.Extension())
+	}
+
+	switch {
+	case strings.HasPrefix(uri, "redis://") || strings.HasPrefix(uri, "rediss://"):
+		res.IsRedis = true
+	case strings.HasPrefix(uri, "redis+cluster://") || strings.HasPrefix(uri, "rediss+cluster://"):
+		res.IsRedis = true
+		res.IsCluster = true
+	case strings.HasPrefix(uri, "redis+sentinel://") || strings.HasPrefix(uri, "rediss+sentinel://"):
+		res.IsRedis = true
+		res.IsSentinel = true
+	case strings.HasPrefix(uri, "redis+psync://") || strings.HasPrefix(uri, "rediss+psync://"):
+		res.IsRedis = true
+		res.IsPSync = true
+	case strings.HasSuffix(path, ".rdb"):
+		res.Format = file.RDB
+	case strings.HasSuffix(path, ".resp"):
+		res.Format = file.RESP
+	case strings.HasSuffix(path, ".ndjson") || strings.HasSuffix(path, ".jsonl"):
+		res.Format = file.NDJSON
+	default:
+		res.Format = file.Rump
+	}
+
+	if !res.IsRedis && c != nil {
+		res.Codec = c.Name()
+	}
+
+	return res
+}
+
+// Redacted returns the URI without credentials.
+func (r Resource) Redacted() string {
+	if !r.IsRedis {
+		return r.URI
+	}
+
+	u, err := url.Parse(r.URI)
+	if err != nil {
+		return r.URI
+	}
+	u.User = nil
+	q := u.Query()
+	if q.Get("sentinel_password") != "" {
+		q.Del("sentinel_password")
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
+
+// ClusterNodes returns the seed nodes of a Redis Cluster URI, separated by
+// commas, and the plain Redis URI of the first one, carrying auth and TLS.
+func (r Resource) ClusterNodes() ([]string, string, error) {
+	u, err := url.Parse(strings.Replace(r.URI, "+cluster://", "://", 1))
+	if err != nil {
+		return nil, "", err
+	}
+	addrs := strings.Split(u.Host, ",")
+	for _, addr := range addrs {
+		if addr == "" {
+			return nil, "", fmt.Errorf("empty redis cluster node in %s", r.Redacted())
+		}
+	}
+	u.Host = addrs[0]
+	return addrs, u.String(), nil
+}
+
+// DB returns the database of a Redis URI, the last path segment,
+// 0 for clusters, files and URIs without one.
+func (r Resource) DB() int {
+	if !r.IsRedis || r.IsCluster {
+		return 0
+	}
+
+	u, err := url.Parse(r.URI)
+	if err != nil {
+		return 0
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	db, err := strconv.Atoi(path[len(path)-1])
+	if err != nil {
+		return 0
+	}
+	return db
+}
+
+// WithDB returns the Resource of another database of a Redis URI,
+// replacing or adding its last path segment.
+func (r Resource) WithDB(db int) Resource {
+	u, err := url.Parse(r.URI)
+	if err != nil {
+		return r
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case path[0] == "":
+		path = []string{strconv.Itoa(db)}
+	case r.IsSentinel && len(path) == 1:
+		path = append(path, strconv.Itoa(db))
+	default:
+		path[len(path)-1] = strconv.Itoa(db)
+	}
+	u.Path = "/" + strings.Join(path, "/")
+
+	res := r
+	res.URI = u.String()
+	return res
+}
+
+// isReplica reports whether a Sentinel URI asks to read from a replica.
+func isReplica(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.Query().Get("replica") == "true"
+}
+
+// validate makes sure from and to are Redis URIs, file paths or -,
 // and generates the final Config.
 func validate(from, to string, silent, ttl bool) (Config, error) {
 	cfg := Config{
-		Source: Resource{
-			URI: from,
-		},
-		Target: Resource{
-			URI: to,
-		},
+		Source: resource(from),
+		Target: resource(to),
 		Silent: silent,
 		TTL:    ttl,
 	}
 
-	if strings.HasPrefix(from, "redis://") || strings.HasPrefix(from, "rediss://") {
-		cfg.Source.IsRedis = true
-	}
-
-	if strings.HasPrefix(to, "redis://") || strings.HasPrefix(to, "rediss://") {
-		cfg.Target.IsRedis = true
-	}
-
 	// Guard from incorrect usage.
 	switch {
 	case cfg.Source.URI == "":
@@ -64,27 +347,590 @@ func validate(from, to string, silent, ttl bool) (Config, error) {
 		return cfg, fmt.Errorf("to is required")
 	case !cfg.Source.IsRedis && !cfg.Target.IsRedis:
 		return cfg, fmt.Errorf("file-only operations not supported")
+	case cfg.Target.IsSentinel && isReplica(cfg.Target.URI):
+		return cfg, fmt.Errorf("sentinel replicas can only be used as source")
+	case cfg.Target.IsPSync:
+		return cfg, fmt.Errorf("psync can only be used as source")
+	}
+
+	for _, res := range []Resource{cfg.Source, cfg.Target} {
+		if !res.IsCluster {
+			continue
+		}
+		if _, _, err := res.ClusterNodes(); err != nil {
+			return cfg, err
+		}
 	}
 
+	// - streams Rump files from stdin or to stdout.
+	cfg.Source.IsStdio = cfg.Source.URI == file.Stdio
+	cfg.Target.IsStdio = cfg.Target.URI == file.Stdio
+
 	return cfg, nil
 }
 
+// parseDBs parses a list of databases: all, or source databases separated
+// by commas, optionally remapped to target databases, like 0,1:5,2:6.
+func parseDBs(s string) (bool, []DB, error) {
+	if s == "all" {
+		return true, nil, nil
+	}
+
+	var dbs []DB
+	seen := map[int]bool{}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, ":", 2)
+		source, err := strconv.Atoi(parts[0])
+		if err != nil || source < 0 {
+			return false, nil, fmt.Errorf("dbs: invalid database %q", item)
+		}
+		target := source
+		if len(parts) == 2 {
+			if target, err = strconv.Atoi(parts[1]); err != nil || target < 0 {
+				return false, nil, fmt.Errorf("dbs: invalid database %q", item)
+			}
+		}
+		if seen[source] {
+			return false, nil, fmt.Errorf("dbs: database %d listed twice", source)
+		}
+		seen[source] = true
+		dbs = append(dbs, DB{Source: source, Target: target})
+	}
+
+	return false, dbs, nil
+}
+
+// validateDBs makes sure several databases are synced between single
+// Redis nodes, Rump and RDB files, in a single complete sync.
+func validateDBs(cfg Config) error {
+	multi := func(res Resource) bool {
+		if res.IsRedis {
+			return !res.IsCluster && !res.IsPSync
+		}
+		return res.Format == file.Rump || res.Format == file.RDB
+	}
+
+	switch {
+	case !cfg.MultiDB():
+		return nil
+	case !multi(cfg.Source) || !multi(cfg.Target):
+		return fmt.Errorf("dbs only supports redis, sentinel, rump and rdb sources and targets, not cluster or psync")
+	case cfg.RDBDB > 0:
+		return fmt.Errorf("rdb-db can't be used with dbs")
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("dbs doesn't support checkpoint")
+	case cfg.Verify || cfg.Mirror || cfg.Follow:
+		return fmt.Errorf("dbs can't be used with verify, mirror or follow")
+	}
+
+	return nil
+}
+
+// validateTargets makes sure several targets are distinct, written
+// without tracking the progress or comparing a single target.
+func validateTargets(cfg Config) error {
+	if len(cfg.Targets) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, res := range cfg.AllTargets() {
+		if seen[res.URI] {
+			return fmt.Errorf("target %s listed twice", res.Redacted())
+		}
+		seen[res.URI] = true
+	}
+
+	switch {
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("checkpoint doesn't support several targets")
+	case cfg.Verify:
+		return fmt.Errorf("verify doesn't support several targets")
+	}
+
+	return nil
+}
+
+// validateSources makes sure several sources are distinct, read as values
+// that can be merged with a known policy, in a single complete sync.
+func validateSources(cfg Config) error {
+	if len(cfg.Sources) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, res := range cfg.AllSources() {
+		if seen[res.URI] {
+			return fmt.Errorf("source %s listed twice", res.Redacted())
+		}
+		seen[res.URI] = true
+		if res.IsPSync || (!res.IsRedis && res.Format != file.Rump && res.Format != file.RDB) {
+			return fmt.Errorf("several sources only supports redis, cluster, sentinel, rump and rdb sources, not psync")
+		}
+	}
+	// Winning values are written again after the values they won over,
+	// the keys of rdb files must be unique.
+	for _, res := range cfg.AllTargets() {
+		if res.Format == file.RDB {
+			return fmt.Errorf("several sources can't be written to rdb target %s", res.Redacted())
+		}
+	}
+
+	known := false
+	for _, p := range merge.Policies {
+		known = known || cfg.Conflict == p
+	}
+
+	switch {
+	case !known:
+		return fmt.Errorf("unknown conflict %s, must be one of %s", cfg.Conflict, strings.Join(merge.Policies, ","))
+	case cfg.Conflict == merge.LongestTTL && !cfg.TTL:
+		return fmt.Errorf("conflict %s requires ttl", merge.LongestTTL)
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("checkpoint doesn't support several sources")
+	case cfg.Verify || cfg.Mirror || cfg.Follow:
+		return fmt.Errorf("several sources can't be used with verify, mirror or follow")
+	case cfg.Logical:
+		return fmt.Errorf("logical doesn't support several sources, values copied with commands can't be merged")
+	case cfg.MultiDB():
+		return fmt.Errorf("dbs doesn't support several sources")
+	}
+
+	return nil
+}
+
+// eachTarget validates the Config of each target with validate.
+func eachTarget(cfg Config, validate func(Config) error) error {
+	for _, res := range cfg.AllTargets() {
+		c := cfg
+		c.Target = res
+		if err := validate(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateFilter makes sure the filter types are Redis types.
+func validateFilter(f filter.Filter) error {
+	for _, t := range f.Types {
+		if !(filter.Filter{Types: types}).MatchType(t) {
+			return fmt.Errorf("unknown type %s, must be one of %s", t, strings.Join(types, ","))
+		}
+	}
+
+	return nil
+}
+
+// validateReplicaFilter makes sure psync sources aren't filtered:
+// replicated commands may name several keys, of any type.
+func validateReplicaFilter(cfg Config) error {
+	f := cfg.Filter
+	if cfg.Source.IsPSync && (len(f.Include) > 0 || len(f.Exclude) > 0 || len(f.Types) > 0) {
+		return fmt.Errorf("psync doesn't support include, exclude or type, replicated commands may name several keys")
+	}
+
+	return nil
+}
+
+// validateCheckpoint makes sure resume is used with a checkpoint,
+// when reading from Redis.
+func validateCheckpoint(cfg Config) error {
+	switch {
+	case cfg.Resume && cfg.Checkpoint == "":
+		return fmt.Errorf("resume requires a checkpoint")
+	case cfg.Checkpoint != "" && (!cfg.Source.IsRedis || cfg.Source.IsPSync):
+		return fmt.Errorf("checkpoint requires a redis source, not psync")
+	case cfg.Checkpoint != "" && !cfg.Target.IsRedis && cfg.Target.Format != file.Rump:
+		return fmt.Errorf("checkpoint only supports rump file targets")
+	case cfg.Checkpoint != "" && cfg.Target.IsStdio:
+		return fmt.Errorf("checkpoint doesn't support stdout targets, they can't be resumed")
+	case cfg.Checkpoint != "" && cfg.CheckpointInterval <= 0:
+		return fmt.Errorf("checkpoint-interval must be positive")
+	}
+
+	return nil
+}
+
+// validateVerify makes sure verify compares with a Redis target,
+// without checkpoint.
+func validateVerify(cfg Config) error {
+	switch {
+	case !cfg.Verify && cfg.VerifyReport != "":
+		return fmt.Errorf("verify-report requires verify")
+	case !cfg.Verify:
+		return nil
+	case !cfg.Target.IsRedis:
+		return fmt.Errorf("verify requires a redis target")
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("verify doesn't support checkpoint")
+	case cfg.Source.IsPSync:
+		return fmt.Errorf("verify doesn't support psync, its sync never completes")
+	case cfg.Source.Format == file.NDJSON:
+		return fmt.Errorf("verify doesn't support ndjson sources, restored with commands")
+	case cfg.TTLWindow < 0:
+		return fmt.Errorf("verify-ttl-window can't be negative")
+	}
+
+	return nil
+}
+
+// validateMirror makes sure mirror deletes keys from a Redis target,
+// after a sync that saw all source keys.
+func validateMirror(cfg Config) error {
+	switch {
+	case !cfg.Mirror:
+		return nil
+	case !cfg.Target.IsRedis:
+		return fmt.Errorf("mirror requires a redis target")
+	case cfg.Verify:
+		return fmt.Errorf("mirror can't be used with verify")
+	case cfg.Source.IsPSync:
+		return fmt.Errorf("mirror doesn't support psync, its sync never completes")
+	case cfg.Resume:
+		return fmt.Errorf("mirror can't be used with resume, the keys synced before are unknown")
+	case cfg.MirrorLimit < 0:
+		return fmt.Errorf("mirror-max-deletes can't be negative")
+	}
+
+	return nil
+}
+
+// validateFollow makes sure follow syncs from Redis to Redis,
+// as a live sync that never completes.
+func validateFollow(cfg Config) error {
+	switch {
+	case !cfg.Follow:
+		return nil
+	case !cfg.Source.IsRedis || !cfg.Target.IsRedis:
+		return fmt.Errorf("follow requires a redis source and target")
+	case cfg.Source.IsPSync:
+		return fmt.Errorf("psync sources already follow changes")
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("follow doesn't support checkpoint")
+	case cfg.Verify || cfg.Mirror:
+		return fmt.Errorf("follow can't be used with verify or mirror")
+	}
+
+	return nil
+}
+
+// validateCompress makes sure compression is used with file targets
+// of known codecs, not appended to.
+func validateCompress(cfg Config) error {
+	switch {
+	case cfg.Target.Codec == "":
+		return nil
+	case cfg.Target.IsRedis:
+		return fmt.Errorf("compress requires a file target")
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("checkpoint doesn't support compressed targets")
+	}
+
+	_, err := codec.Get(cfg.Target.Codec)
+	return err
+}
+
+// encryptionKey reads the encryption key from path, or else from
+// the RUMP_ENCRYPTION_KEY env var. It returns nil without a key.
+func encryptionKey(path string) ([]byte, error) {
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return file.ParseKey(b)
+	}
+
+	if k := os.Getenv("RUMP_ENCRYPTION_KEY"); k != "" {
+		return file.ParseKey([]byte(k))
+	}
+	return nil, nil
+}
+
+// validateEncryption makes sure encrypted file targets aren't appended to.
+func validateEncryption(cfg Config) error {
+	if cfg.EncryptionKey != nil && cfg.Checkpoint != "" {
+		return fmt.Errorf("checkpoint doesn't support encrypted targets")
+	}
+
+	return nil
+}
+
+// validateLogical makes sure logical copies read from Redis,
+// to targets able to store commands.
+func validateLogical(cfg Config) error {
+	switch {
+	case !cfg.Logical:
+		return nil
+	case !cfg.Source.IsRedis || cfg.Source.IsPSync:
+		return fmt.Errorf("logical requires a redis source, not psync")
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("logical doesn't support checkpoint")
+	case cfg.Verify:
+		return fmt.Errorf("logical can't be used with verify, comparing DUMP payloads")
+	case cfg.Target.Format == file.RDB:
+		return fmt.Errorf("logical doesn't support rdb targets")
+	}
+
+	return nil
+}
+
+// validateTransform makes sure transformed values aren't compared
+// with the target, and that all the commands written can be masked.
+func validateTransform(cfg Config) error {
+	switch {
+	case cfg.Transform == nil:
+		return nil
+	case cfg.Source.IsPSync:
+		return fmt.Errorf("transform doesn't support psync, replicated commands like MSET or RESTORE can't be masked")
+	case cfg.Verify:
+		return fmt.Errorf("transform can't be used with verify, the target values differ")
+	}
+
+	return nil
+}
+
+// validateRename makes sure renamed keys are only written to the target,
+// the checkpoint, verify and mirror tracking source keys, and that the
+// renamed keys are known.
+func validateRename(cfg Config) error {
+	switch {
+	case len(cfg.Rename) == 0:
+		return nil
+	case cfg.Source.IsPSync:
+		return fmt.Errorf("rename doesn't support psync, replicated commands may name several keys")
+	case cfg.Checkpoint != "":
+		return fmt.Errorf("rename doesn't support checkpoint, tracking source keys")
+	case cfg.Verify:
+		return fmt.Errorf("rename can't be used with verify, comparing source keys")
+	case cfg.Mirror:
+		return fmt.Errorf("rename can't be used with mirror, source key patterns can't select the renamed target keys")
+	}
+
+	return nil
+}
+
 // Parse parses the command line flags and returns a Config.
 func Parse() Config {
-	example := "example: redis://127.0.0.1:6379/0 or /tmp/dump.rump"
-	from := flag.String("from", "", example)
-	to := flag.String("to", "", example)
+	example := "example: redis://127.0.0.1:6379/0, redis+cluster://10.0.0.1:6379,10.0.0.2:6379, redis+sentinel://10.0.0.1:26379/mymaster/0?replica=true, redis+psync://127.0.0.1:6379/0, /tmp/dump.rump, /tmp/dump.rdb, /tmp/dump.resp, /tmp/dump.ndjson or - for stdin/stdout"
+	var from list
+	flag.Var(&from, "from", "repeatable, merging all sources, "+example)
+	var to list
+	flag.Var(&to, "to", "repeatable, writing all targets from a single read, "+example)
 	silent := flag.Bool("silent", false, "optional, no verbose output")
 	ttl := flag.Bool("ttl", false, "optional, enable ttl sync")
+	absTTL := flag.Bool("absttl", false, "optional, enable ttl sync restoring absolute expire times (RESTORE ABSTTL, Redis >= 5.0)")
+	var include, exclude, keyTypes list
+	flag.Var(&include, "include", "optional, repeatable, only sync keys matching a glob pattern, example: session:*")
+	flag.Var(&exclude, "exclude", "optional, repeatable, skip keys matching a glob pattern, example: session:tmp:*")
+	flag.Var(&keyTypes, "type", "optional, repeatable, only sync keys of a type: "+strings.Join(types, ","))
+	readBatch := flag.Int("read-batch", 400, "optional, keys per SCAN page, dumped with a single pipeline")
+	readDepth := flag.Int("read-depth", 4, "optional, dump pipelines in flight")
+	writers := flag.Int("writers", 4, "optional, goroutines restoring keys")
+	writeBatch := flag.Int("write-batch", 100, "optional, maximum keys restored with a single pipeline")
+	checkpoint := flag.String("checkpoint", "", "optional, file saving the sync progress, example: /tmp/dump.checkpoint")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "optional, interval between checkpoint saves")
+	resume := flag.Bool("resume", false, "optional, resume the sync saved to checkpoint, appending to file targets")
+	verify := flag.Bool("verify", false, "optional, compare the source with the redis target instead of syncing, exit non-zero on differences")
+	verifyReport := flag.String("verify-report", "", "optional, file listing verify differences as JSON lines, example: /tmp/verify.json")
+	mirror := flag.Bool("mirror", false, "optional, delete the redis target keys absent from the source after a complete sync")
+	mirrorDryRun := flag.Bool("mirror-dry-run", false, "optional, list the keys mirror would delete, without deleting them")
+	mirrorLimit := flag.Int("mirror-max-deletes", 1000, "optional, abort mirror with more keys to delete, 0 for no limit")
+	follow := flag.Bool("follow", false, "optional, keep syncing source changes from keyspace notifications until interrupted")
+	rdbDB := flag.Int("rdb-db", 0, "optional, database read from .rdb file sources, or written to .rdb file targets, see dbs for several")
+	compress := flag.String("compress", "", "optional, compress file targets with a codec: "+strings.Join(codec.Names(), ",")+", detected from the target extension, example: /tmp/dump.rump.gz")
+	keyFile := flag.String("encryption-key-file", "", "optional, file of the 32 bytes key, raw or in hex or base64, encrypting file targets and decrypting file sources with AES-256-GCM, RUMP_ENCRYPTION_KEY by default")
+	logical := flag.Bool("logical", false, "optional, copy values with type-specific and native commands instead of DUMP and RESTORE, across Redis versions")
+	transformRules := flag.String("transform", "", "optional, JSON file of rules hashing, redacting or replacing data in the values of matching keys, example: /etc/rump/staging.json")
+	var renames list
+	flag.Var(&renames, "rename", "optional, repeatable, rewrite keys in order with strip-prefix=PREFIX, add-prefix=PREFIX or regex=REGEXP=>REPLACEMENT expanding $1, example: strip-prefix=app1:")
+	renameCollisions := flag.String("rename-collisions", "abort", "optional, on two source keys renamed to the same target key: abort or report")
+	conflict := flag.String("conflict", merge.FirstWins, "optional, on keys read from several sources, keep the value of: "+strings.Join(merge.Policies, ","))
+	targetFailure := flag.String("target-failure", "abort", "optional, on the failure of one of several targets: abort, or continue with the others and report at the end")
+	dbs := flag.String("dbs", "", "optional, sync all databases, or a list of databases remapped with source:target, instead of the URIs one, example: 0,1:5,2:6")
+	ttlWindow := flag.Duration("verify-ttl-window", 5*time.Second, "optional, tolerance of verify comparing expire times with ttl")
 
 	flag.Parse()
 
-	cfg, err := validate(*from, *to, *silent, *ttl)
+	if len(from) == 0 {
+		from = list{""}
+	}
+	if len(to) == 0 {
+		to = list{""}
+	}
+	cfg, err := validate(from[0], to[0], *silent, *ttl)
 	if err != nil {
 		// we exit here instead of returning so that we can show
 		// the usage examples in case of an error.
 		exit(err)
 	}
+	// Each source is validated with each target.
+	for i, source := range from {
+		for j, target := range to {
+			if i == 0 && j == 0 {
+				continue
+			}
+			c, err := validate(source, target, *silent, *ttl)
+			if err != nil {
+				exit(err)
+			}
+			if i == 0 {
+				cfg.Targets = append(cfg.Targets, c.Target)
+			}
+			if j == 0 {
+				cfg.Sources = append(cfg.Sources, c.Source)
+			}
+		}
+	}
+	cfg.Conflict = *conflict
+	switch *targetFailure {
+	case "abort":
+		cfg.TargetAbort = true
+	case "continue":
+	default:
+		exit(fmt.Errorf("target-failure must be abort or continue"))
+	}
+
+	if *absTTL {
+		cfg.TTL = true
+		cfg.AbsTTL = true
+	}
+
+	cfg.Filter = filter.Filter{
+		Include: include,
+		Exclude: exclude,
+		Types:   keyTypes,
+	}
+	if err := validateFilter(cfg.Filter); err != nil {
+		exit(err)
+	}
+	if err := validateReplicaFilter(cfg); err != nil {
+		exit(err)
+	}
+
+	if *readBatch < 1 || *readDepth < 1 {
+		exit(fmt.Errorf("read-batch and read-depth must be positive"))
+	}
+	cfg.ReadBatch = *readBatch
+	cfg.ReadDepth = *readDepth
+
+	if *writers < 1 || *writeBatch < 1 {
+		exit(fmt.Errorf("writers and write-batch must be positive"))
+	}
+	cfg.Writers = *writers
+	cfg.WriteBatch = *writeBatch
+
+	cfg.Checkpoint = *checkpoint
+	cfg.CheckpointInterval = *checkpointInterval
+	cfg.Resume = *resume
+	if err := validateTargets(cfg); err != nil {
+		exit(err)
+	}
+	if err := eachTarget(cfg, validateCheckpoint); err != nil {
+		exit(err)
+	}
+
+	cfg.Verify = *verify
+	cfg.VerifyReport = *verifyReport
+	cfg.TTLWindow = *ttlWindow
+	if err := eachTarget(cfg, validateVerify); err != nil {
+		exit(err)
+	}
+
+	cfg.Mirror = *mirror || *mirrorDryRun
+	cfg.MirrorDryRun = *mirrorDryRun
+	cfg.MirrorLimit = *mirrorLimit
+	if err := eachTarget(cfg, validateMirror); err != nil {
+		exit(err)
+	}
+
+	cfg.Follow = *follow
+	if err := eachTarget(cfg, validateFollow); err != nil {
+		exit(err)
+	}
+
+	if *rdbDB < 0 {
+		exit(fmt.Errorf("rdb-db can't be negative"))
+	}
+	rdb := false
+	for _, res := range append(cfg.AllSources(), cfg.AllTargets()...) {
+		rdb = rdb || res.Format == file.RDB
+	}
+	if *rdbDB > 0 && !rdb {
+		exit(fmt.Errorf("rdb-db requires an rdb source or target"))
+	}
+	cfg.RDBDB = *rdbDB
+
+	if *compress != "" && len(cfg.Targets) > 0 {
+		exit(fmt.Errorf("compress doesn't support several targets, compress them with their extension, example: /tmp/dump.rump.gz"))
+	}
+	if *compress != "" {
+		cfg.Target.Codec = *compress
+	}
+	if err := eachTarget(cfg, validateCompress); err != nil {
+		exit(err)
+	}
+
+	key, err := encryptionKey(*keyFile)
+	if err != nil {
+		exit(err)
+	}
+	if *keyFile != "" && !cfg.fileSource() && !cfg.fileTarget() {
+		exit(fmt.Errorf("encryption-key-file requires a file source or target"))
+	}
+	if cfg.fileSource() || cfg.fileTarget() {
+		cfg.EncryptionKey = key
+	}
+	if err := validateEncryption(cfg); err != nil {
+		exit(err)
+	}
+
+	cfg.Logical = *logical
+	if err := eachTarget(cfg, validateLogical); err != nil {
+		exit(err)
+	}
+
+	if *transformRules != "" {
+		if cfg.Transform, err = transform.Load(*transformRules); err != nil {
+			exit(err)
+		}
+	}
+	if err := validateTransform(cfg); err != nil {
+		exit(err)
+	}
+
+	for _, s := range renames {
+		rule, err := rename.Parse(s)
+		if err != nil {
+			exit(err)
+		}
+		cfg.Rename = append(cfg.Rename, rule)
+	}
+	switch *renameCollisions {
+	case "abort":
+		cfg.RenameAbort = true
+	case "report":
+	default:
+		exit(fmt.Errorf("rename-collisions must be abort or report"))
+	}
+	if err := validateRename(cfg); err != nil {
+		exit(err)
+	}
+
+	if *dbs != "" {
+		if cfg.AllDBs, cfg.DBs, err = parseDBs(*dbs); err != nil {
+			exit(err)
+		}
+	}
+	if err := eachTarget(cfg, validateDBs); err != nil {
+		exit(err)
+	}
+
+	if err := validateSources(cfg); err != nil {
+		exit(err)
+	}
 
 	return cfg
 }
@@ -1,7 +1,17 @@
 package config
 
 import (
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/domwong/rump/pkg/file"
+	"github.com/domwong/rump/pkg/filter"
+	"github.com/domwong/rump/pkg/merge"
+	"github.com/domwong/rump/pkg/rename"
+	"github.com/domwong/rump/pkg/transform"
 )
 
 func TestNoRedis(t *testing.T) {
@@ -93,3 +103,533 @@ func TestFromFileToRedis(t *testing.T) {
 		t.Error("wrong target")
 	}
 }
+
+func TestStdio(t *testing.T) {
+	cfg, err := validate("redis://s", "-", false, false)
+	if err != nil || !cfg.Target.IsStdio || cfg.Source.IsStdio || cfg.Target.Format != file.Rump {
+		t.Error("from redis to stdout should work")
+	}
+
+	cfg, err = validate("-", "redis://t", false, false)
+	if err != nil || !cfg.Source.IsStdio || cfg.Target.IsStdio {
+		t.Error("from stdin to redis should work")
+	}
+
+	if _, err := validate("-", "-", false, false); err == nil {
+		t.Error("from stdin to stdout should fail")
+	}
+
+	cfg, _ = validate("redis://s", "-", false, false)
+	cfg.Checkpoint = "/t.checkpoint"
+	cfg.CheckpointInterval = time.Second
+	if err := validateCheckpoint(cfg); err == nil {
+		t.Error("checkpoint should require a resumable target")
+	}
+}
+
+func TestFromClusterToRedis(t *testing.T) {
+	cfg, err := validate("redis+cluster://s1:7000,s2:7000", "redis://t", false, false)
+	if err != nil {
+		t.Error("from cluster to redis should work")
+	}
+
+	if !cfg.Source.IsRedis || !cfg.Source.IsCluster {
+		t.Error("wrong from")
+	}
+
+	if !cfg.Target.IsRedis || cfg.Target.IsCluster {
+		t.Error("wrong to")
+	}
+}
+
+func TestClusterNodes(t *testing.T) {
+	cfg, err := validate("rediss+cluster://:pw@s1:7000,s2:7001,s3:7002", "redis://t", false, false)
+	if err != nil || !cfg.Source.IsCluster || cfg.Source.DB() != 0 {
+		t.Errorf("from a tls cluster should work, error %v", err)
+	}
+
+	addrs, seed, err := cfg.Source.ClusterNodes()
+	if err != nil || !reflect.DeepEqual(addrs, []string{"s1:7000", "s2:7001", "s3:7002"}) || seed != "rediss://:pw@s1:7000" {
+		t.Errorf("unexpected nodes %v, seed %s, error %v", addrs, seed, err)
+	}
+	if r := cfg.Source.Redacted(); r != "rediss+cluster://s1:7000,s2:7001,s3:7002" {
+		t.Errorf("unexpected redacted uri %s", r)
+	}
+
+	if _, err := validate("redis+cluster://s1:7000,,s2:7000", "redis://t", false, false); err == nil {
+		t.Error("empty cluster nodes should not be supported")
+	}
+}
+
+func TestFromFileToCluster(t *testing.T) {
+	cfg, err := validate("/s.rump", "rediss+cluster://t:7000", false, false)
+	if err != nil {
+		t.Error("from file to cluster should work")
+	}
+
+	if cfg.Source.IsRedis {
+		t.Error("wrong from")
+	}
+
+	if !cfg.Target.IsRedis || !cfg.Target.IsCluster {
+		t.Error("wrong to")
+	}
+}
+
+func TestFromSentinelToSentinel(t *testing.T) {
+	cfg, err := validate("redis+sentinel://s1:26379,s2:26379/mymaster/1?replica=true", "redis+sentinel://t:26379/mymaster/2", false, false)
+	if err != nil {
+		t.Error("from sentinel to sentinel should work")
+	}
+
+	if !cfg.Source.IsRedis || !cfg.Source.IsSentinel {
+		t.Error("wrong from")
+	}
+
+	if !cfg.Target.IsRedis || !cfg.Target.IsSentinel {
+		t.Error("wrong to")
+	}
+}
+
+func TestToSentinelReplica(t *testing.T) {
+	_, err := validate("redis://s", "redis+sentinel://t:26379/mymaster/0?replica=true", false, false)
+	if err == nil {
+		t.Error("sentinel replica target should not be supported")
+	}
+}
+
+func TestFromPSyncToRedis(t *testing.T) {
+	cfg, err := validate("redis+psync://s:6379/1", "redis://t", false, false)
+	if err != nil {
+		t.Error("from psync to redis should work")
+	}
+
+	if !cfg.Source.IsRedis || !cfg.Source.IsPSync {
+		t.Error("wrong from")
+	}
+
+	_, err = validate("redis://s", "redis+psync://t:6379/1", false, false)
+	if err == nil {
+		t.Error("psync target should not be supported")
+	}
+}
+
+func TestRDB(t *testing.T) {
+	cfg, err := validate("/s.rdb", "redis://t", false, false)
+	if err != nil {
+		t.Error("from rdb to redis should work")
+	}
+
+	if cfg.Source.IsRedis || cfg.Source.Format != file.RDB {
+		t.Error("wrong from")
+	}
+
+	cfg, err = validate("redis://s", "/t.rdb", false, false)
+	if err != nil {
+		t.Error("from redis to rdb should work")
+	}
+
+	cfg.Checkpoint = "/t.checkpoint"
+	cfg.CheckpointInterval = time.Second
+	if err := validateCheckpoint(cfg); err == nil {
+		t.Error("checkpoint should not support rdb targets")
+	}
+}
+
+func TestRESP(t *testing.T) {
+	cfg, err := validate("redis://s", "/t.resp", false, false)
+	if err != nil {
+		t.Error("from redis to resp should work")
+	}
+
+	if cfg.Target.IsRedis || cfg.Target.Format != file.RESP {
+		t.Error("wrong to")
+	}
+
+	cfg.Checkpoint = "/t.checkpoint"
+	cfg.CheckpointInterval = time.Second
+	if err := validateCheckpoint(cfg); err == nil {
+		t.Error("checkpoint should not support resp targets")
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	cfg, err := validate("/s.ndjson", "redis://t", false, false)
+	if err != nil {
+		t.Error("from ndjson to redis should work")
+	}
+
+	if cfg.Source.IsRedis || cfg.Source.Format != file.NDJSON {
+		t.Error("wrong from")
+	}
+
+	cfg.Verify = true
+	if err := validateVerify(cfg); err == nil {
+		t.Error("verify should not support ndjson sources")
+	}
+}
+
+func TestFilterTypes(t *testing.T) {
+	if err := validateFilter(filter.Filter{Types: []string{"hash", "zset"}}); err != nil {
+		t.Error("redis types should be supported")
+	}
+
+	if err := validateFilter(filter.Filter{Types: []string{"hashes"}}); err == nil {
+		t.Error("unknown types should not be supported")
+	}
+}
+
+func TestReplicaFilter(t *testing.T) {
+	cfg, _ := validate("redis+psync://s:6379/1", "redis://t", false, false)
+	if err := validateReplicaFilter(cfg); err != nil {
+		t.Error("psync without filter should work")
+	}
+
+	cfg.Filter = filter.Filter{Exclude: []string{"session:*"}}
+	if err := validateReplicaFilter(cfg); err == nil {
+		t.Error("psync shouldn't support filters")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	res := resource("redis+sentinel://user:pw@s:26379/mymaster/0?replica=true&sentinel_password=spw")
+	if res.Redacted() != "redis+sentinel://s:26379/mymaster/0?replica=true" {
+		t.Errorf("wrong redacted uri %s", res.Redacted())
+	}
+}
+
+func TestDB(t *testing.T) {
+	dbs := map[string]int{
+		"redis://s:6379/2":                 2,
+		"redis://s:6379":                   0,
+		"redis+sentinel://s/mymaster/3":    3,
+		"redis+psync://s/4":                4,
+		"redis+cluster://s1:7000,s2:7001/": 0,
+		"/s.rump":                          0,
+	}
+	for uri, db := range dbs {
+		if res := resource(uri); res.DB() != db {
+			t.Errorf("%s: wrong db %d, expected %d", uri, res.DB(), db)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	cfg, _ := validate("redis://s", "/t.rump", false, false)
+	cfg.Resume = true
+	if err := validateCheckpoint(cfg); err == nil {
+		t.Error("resume should require a checkpoint")
+	}
+
+	cfg.Checkpoint = "/t.checkpoint"
+	cfg.CheckpointInterval = time.Second
+	if err := validateCheckpoint(cfg); err != nil {
+		t.Error("resume from redis should work")
+	}
+
+	cfg, _ = validate("/s.rump", "redis://t", false, false)
+	cfg.Checkpoint = "/t.checkpoint"
+	cfg.CheckpointInterval = time.Second
+	if err := validateCheckpoint(cfg); err == nil {
+		t.Error("checkpoint should require a redis source")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	cfg, _ := validate("redis://s", "/t.rump", false, false)
+	cfg.Verify = true
+	if err := validateVerify(cfg); err == nil {
+		t.Error("verify should require a redis target")
+	}
+
+	cfg, _ = validate("/s.rump", "redis://t", false, false)
+	cfg.VerifyReport = "/t.json"
+	if err := validateVerify(cfg); err == nil {
+		t.Error("verify-report should require verify")
+	}
+
+	cfg.Verify = true
+	if err := validateVerify(cfg); err != nil {
+		t.Error("verify from file to redis should work")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	cfg, _ := validate("redis://s", "/t.rump", false, false)
+	cfg.Mirror = true
+	if err := validateMirror(cfg); err == nil {
+		t.Error("mirror should require a redis target")
+	}
+
+	cfg, _ = validate("redis://s", "redis://t", false, false)
+	cfg.Mirror = true
+	cfg.Checkpoint = "/t.checkpoint"
+	cfg.Resume = true
+	if err := validateMirror(cfg); err == nil {
+		t.Error("mirror should not support resume")
+	}
+
+	cfg.Resume = false
+	if err := validateMirror(cfg); err != nil {
+		t.Error("mirror from redis to redis should work")
+	}
+}
+
+func TestFollow(t *testing.T) {
+	cfg, _ := validate("redis://s", "/t.rump", false, false)
+	cfg.Follow = true
+	if err := validateFollow(cfg); err == nil {
+		t.Error("follow should require a redis target")
+	}
+
+	cfg, _ = validate("redis://s", "redis://t", false, false)
+	cfg.Follow = true
+	if err := validateFollow(cfg); err != nil {
+		t.Error("follow from redis to redis should work")
+	}
+}
+
+func TestLogical(t *testing.T) {
+	cfg, _ := validate("/s.rump", "redis://t", false, false)
+	cfg.Logical = true
+	if err := validateLogical(cfg); err == nil {
+		t.Error("logical should require a redis source")
+	}
+
+	cfg, _ = validate("redis://s", "redis://t", false, false)
+	cfg.Logical = true
+	cfg.Verify = true
+	if err := validateLogical(cfg); err == nil {
+		t.Error("logical should not support verify")
+	}
+
+	cfg.Verify = false
+	if err := validateLogical(cfg); err != nil {
+		t.Error("logical from redis to redis should work")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	cfg, err := validate("redis://s", "/t.ndjson.gz", false, false)
+	if err != nil {
+		t.Error("from redis to compressed file should work")
+	}
+
+	if cfg.Target.Format != file.NDJSON || cfg.Target.Codec != "gzip" {
+		t.Error("wrong to")
+	}
+	if err := validateCompress(cfg); err != nil {
+		t.Error("gzip should be supported")
+	}
+
+	cfg.Target.Codec = "lz4"
+	if err := validateCompress(cfg); err == nil {
+		t.Error("unknown codecs should not be supported")
+	}
+
+	cfg, _ = validate("redis://s", "/t.rump", false, false)
+	cfg.Target.Codec = "gzip"
+	cfg.Checkpoint = "/t.checkpoint"
+	if err := validateCompress(cfg); err == nil {
+		t.Error("checkpoint should not support compressed targets")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	os.Setenv("RUMP_ENCRYPTION_KEY", strings.Repeat("ab", 32))
+	defer os.Unsetenv("RUMP_ENCRYPTION_KEY")
+
+	key, err := encryptionKey("")
+	if err != nil || len(key) != 32 {
+		t.Errorf("hex key from env should work: %v", err)
+	}
+
+	os.Setenv("RUMP_ENCRYPTION_KEY", "short")
+	if _, err := encryptionKey(""); err == nil {
+		t.Error("short keys should not be supported")
+	}
+
+	cfg, _ := validate("redis://s", "/t.rump", false, false)
+	cfg.EncryptionKey = key
+	cfg.Checkpoint = "/t.checkpoint"
+	if err := validateEncryption(cfg); err == nil {
+		t.Error("checkpoint should not support encrypted targets")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	cfg, _ := validate("redis://s", "redis://t", false, false)
+	cfg.Transform = &transform.Rules{}
+	if err := validateTransform(cfg); err != nil {
+		t.Error("transform should work")
+	}
+
+	cfg.Verify = true
+	if err := validateTransform(cfg); err == nil {
+		t.Error("transform shouldn't work with verify")
+	}
+
+	cfg, _ = validate("redis+psync://s", "redis://t", false, false)
+	cfg.Transform = &transform.Rules{}
+	if err := validateTransform(cfg); err == nil {
+		t.Error("transform shouldn't work with psync")
+	}
+}
+
+func TestRename(t *testing.T) {
+	cfg, _ := validate("redis://s", "redis://t", false, false)
+	cfg.Rename = []rename.Rule{{Kind: rename.AddPrefix, Prefix: "app1:"}}
+	if err := validateRename(cfg); err != nil {
+		t.Error("rename should work")
+	}
+
+	cfg.Checkpoint = "/t.checkpoint"
+	if err := validateRename(cfg); err == nil {
+		t.Error("rename shouldn't work with checkpoint")
+	}
+
+	cfg.Checkpoint = ""
+	cfg.Mirror = true
+	if err := validateRename(cfg); err == nil {
+		t.Error("rename shouldn't work with mirror")
+	}
+
+	cfg, _ = validate("redis+psync://s", "redis://t", false, false)
+	cfg.Rename = []rename.Rule{{Kind: rename.AddPrefix, Prefix: "app1:"}}
+	if err := validateRename(cfg); err == nil {
+		t.Error("rename shouldn't work with psync")
+	}
+}
+
+func TestWithDB(t *testing.T) {
+	uris := map[string]string{
+		"redis://s:6379/2":              "redis://s:6379/5",
+		"redis://u:p@s:6379":            "redis://u:p@s:6379/5",
+		"redis+sentinel://s/mymaster/3": "redis+sentinel://s/mymaster/5",
+		"redis+sentinel://s/mymaster":   "redis+sentinel://s/mymaster/5",
+	}
+	for uri, expected := range uris {
+		if res := resource(uri).WithDB(5); res.URI != expected || res.DB() != 5 {
+			t.Errorf("%s: expected %s, result %s", uri, expected, res.URI)
+		}
+	}
+}
+
+func TestDBs(t *testing.T) {
+	all, dbs, err := parseDBs("0,1:5,2:6")
+	if all || err != nil || !reflect.DeepEqual(dbs, []DB{{0, 0}, {1, 5}, {2, 6}}) {
+		t.Errorf("unexpected dbs %v, error %v", dbs, err)
+	}
+	for _, s := range []string{"a", "1:", "-1", "1,1:2"} {
+		if _, _, err := parseDBs(s); err == nil {
+			t.Errorf("%s should be invalid", s)
+		}
+	}
+
+	cfg, _ := validate("redis://s", "/t.rump", false, false)
+	cfg.AllDBs, cfg.DBs, _ = parseDBs("all")
+	if err := validateDBs(cfg); err != nil {
+		t.Error("all dbs to a rump file should work")
+	}
+	if db, ok := cfg.TargetDB(3); !ok || db != 3 {
+		t.Error("all dbs should keep databases")
+	}
+
+	cfg, _ = validate("/s.rdb", "redis://t", false, false)
+	cfg.DBs = dbs
+	if err := validateDBs(cfg); err != nil {
+		t.Error("dbs from an rdb file should work")
+	}
+	if db, ok := cfg.TargetDB(1); !ok || db != 5 {
+		t.Error("dbs should remap databases")
+	}
+	if _, ok := cfg.TargetDB(3); ok {
+		t.Error("unlisted dbs shouldn't be synced")
+	}
+
+	cfg, _ = validate("redis+cluster://s", "redis://t", false, false)
+	cfg.DBs = dbs
+	if err := validateDBs(cfg); err == nil {
+		t.Error("dbs shouldn't work with cluster")
+	}
+
+	cfg, _ = validate("redis://s", "/t.ndjson", false, false)
+	cfg.DBs = dbs
+	if err := validateDBs(cfg); err == nil {
+		t.Error("dbs shouldn't work with ndjson")
+	}
+}
+
+func TestTargets(t *testing.T) {
+	cfg, _ := validate("redis://s", "redis://t1", false, false)
+	t2, _ := validate("redis://s", "/t2.rump", false, false)
+	cfg.Targets = []Resource{t2.Target}
+	if err := validateTargets(cfg); err != nil {
+		t.Error("several targets should work")
+	}
+	if targets := cfg.AllTargets(); len(targets) != 2 || targets[0].URI != "redis://t1" || !cfg.fileTarget() {
+		t.Errorf("unexpected targets %v", targets)
+	}
+
+	cfg.Mirror = true
+	if err := eachTarget(cfg, validateMirror); err == nil {
+		t.Error("mirror should require redis targets")
+	}
+	cfg.Mirror = false
+
+	cfg.Checkpoint = "/t.checkpoint"
+	if err := validateTargets(cfg); err == nil {
+		t.Error("checkpoint shouldn't work with several targets")
+	}
+	cfg.Checkpoint = ""
+
+	cfg.Targets = append(cfg.Targets, cfg.Target)
+	if err := validateTargets(cfg); err == nil {
+		t.Error("targets should be distinct")
+	}
+}
+
+func TestSources(t *testing.T) {
+	cfg, _ := validate("redis://s1", "redis://t", false, false)
+	s2, _ := validate("/s2.rump", "redis://t", false, false)
+	cfg.Sources = []Resource{s2.Source}
+	cfg.Conflict = merge.FirstWins
+	if err := validateSources(cfg); err != nil {
+		t.Error("several sources should work")
+	}
+	if sources := cfg.AllSources(); len(sources) != 2 || sources[1].URI != "/s2.rump" || !cfg.fileSource() {
+		t.Errorf("unexpected sources %v", sources)
+	}
+
+	cfg.Conflict = "random"
+	if err := validateSources(cfg); err == nil {
+		t.Error("unknown conflicts should not be supported")
+	}
+	cfg.Conflict = merge.LongestTTL
+	if err := validateSources(cfg); err == nil {
+		t.Error("longest-ttl should require ttl")
+	}
+	cfg.TTL = true
+	if err := validateSources(cfg); err != nil {
+		t.Error("longest-ttl with ttl should work")
+	}
+
+	cfg.Mirror = true
+	if err := validateSources(cfg); err == nil {
+		t.Error("mirror shouldn't work with several sources")
+	}
+	cfg.Mirror = false
+
+	rdbTarget, _ := validate("redis://s1", "/t.rdb", false, false)
+	cfg.Targets = []Resource{rdbTarget.Target}
+	if err := validateSources(cfg); err == nil {
+		t.Error("several sources shouldn't be written to rdb files")
+	}
+	cfg.Targets = nil
+
+	s2, _ = validate("/s2.ndjson", "redis://t", false, false)
+	cfg.Sources = []Resource{s2.Source}
+	if err := validateSources(cfg); err == nil {
+		t.Error("ndjson sources shouldn't be merged")
+	}
+}
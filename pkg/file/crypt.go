@@ -0,0 +1,223 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted files start with cryptMagic and a random nonce prefix,
+// followed by frames of a 4 bytes big endian length and a chunk of up
+// to chunkSize bytes sealed with AES-256-GCM.
+// Chunk nonces are the nonce prefix and the chunk counter, and the last
+// chunk is authenticated as such, so that reordered, truncated or
+// extended files fail to decrypt.
+const (
+	cryptMagic = "RUMPAES1"
+	prefixSize = 8
+	chunkSize  = 64 * 1024
+)
+
+// ErrDecrypt is returned reading encrypted files with a wrong key,
+// or tampered with.
+var ErrDecrypt = errors.New("file: decryption failed, wrong key or tampered file")
+
+// KeySize is the size of encryption keys.
+const KeySize = 32
+
+// ParseKey parses an encryption key of KeySize bytes, raw or encoded
+// in hex or base64. Surrounding spaces are ignored.
+func ParseKey(b []byte) ([]byte, error) {
+	if len(b) == KeySize {
+		return b, nil
+	}
+
+	s := string(bytes.TrimSpace(b))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("file: encryption keys must be %d bytes, raw or encoded in hex or base64", KeySize)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of chunk n.
+func nonce(prefix []byte, n uint32) []byte {
+	b := make([]byte, prefixSize+4)
+	copy(b, prefix)
+	binary.BigEndian.PutUint32(b[prefixSize:], n)
+	return b
+}
+
+// additional data of the last chunk, and of the others.
+var (
+	lastChunk = []byte{1}
+	nextChunk = []byte{0}
+)
+
+// encryptWriter encrypts to w in chunks. Close writes the last chunk.
+type encryptWriter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, cryptMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, gcm: gcm, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Keep a full chunk buffered, it may be the last one.
+		if len(e.buf) == chunkSize {
+			if err := e.seal(nextChunk); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// seal writes the buffered chunk as a frame.
+func (e *encryptWriter) seal(ad []byte) error {
+	if e.n == ^uint32(0) {
+		return fmt.Errorf("file: too many encrypted chunks")
+	}
+	sealed := e.gcm.Seal(nil, nonce(e.prefix, e.n), e.buf, ad)
+	e.n++
+	e.buf = e.buf[:0]
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close writes the last chunk, possibly empty. It must only be called once
+// all the data was written, the file being authenticated as complete.
+func (e *encryptWriter) Close() error {
+	return e.seal(lastChunk)
+}
+
+// decryptReader decrypts the chunks of an encrypted file.
+type decryptReader struct {
+	r      io.Reader
+	gcm    cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+	done   bool
+}
+
+// encrypted reports whether r starts as an encrypted file.
+func encrypted(r *bufio.Reader) bool {
+	b, _ := r.Peek(len(cryptMagic))
+	return string(b) == cryptMagic
+}
+
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(cryptMagic)+prefixSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(cryptMagic)]) != cryptMagic {
+		return nil, fmt.Errorf("file: invalid encrypted file")
+	}
+
+	return &decryptReader{r: r, gcm: gcm, prefix: header[len(cryptMagic):]}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk.
+func (d *decryptReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return ErrDecrypt
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > chunkSize+uint32(d.gcm.Overhead()) {
+		return ErrDecrypt
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrDecrypt
+	}
+
+	iv := nonce(d.prefix, d.n)
+	chunk, err := d.gcm.Open(nil, iv, sealed, nextChunk)
+	if err != nil {
+		// Only the last chunk is authenticated as such.
+		if chunk, err = d.gcm.Open(nil, iv, sealed, lastChunk); err != nil {
+			return ErrDecrypt
+		}
+		d.done = true
+
+		// Nothing may follow the last chunk.
+		var b [1]byte
+		if _, err := io.ReadFull(d.r, b[:]); err != io.EOF {
+			return ErrDecrypt
+		}
+	}
+	d.n++
+	d.buf = chunk
+	return nil
+}
@@ -3,28 +3,82 @@ package file
 import (
 	"bufio"
 	"context"
+	"encoding/binary"
 	"fmt"
-	"github.com/domwong/rump/pkg/message"
-	gogoio "github.com/gogo/protobuf/io"
 	"io"
 	"os"
+	"time"
+
+	"github.com/domwong/rump/pkg/checkpoint"
+	"github.com/domwong/rump/pkg/codec"
+	"github.com/domwong/rump/pkg/filter"
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+	gogoio "github.com/gogo/protobuf/io"
+)
+
+// File formats.
+const (
+	// Rump is the delimited protobuf format of Payloads,
+	// with a Header and a Trailer.
+	Rump = "rump"
+	// RDB is the Redis snapshot format.
+	RDB = "rdb"
+	// RESP is the Redis protocol format of redis-cli --pipe.
+	RESP = "resp"
+	// NDJSON is the JSON lines format of logical values.
+	NDJSON = "ndjson"
 )
 
+// Stdio is the Path of files read from stdin, or written to stdout.
+const Stdio = "-"
+
 // File can read and write, to a file Path, using the message Bus.
+// Format is the file format, Rump by default.
+// Filter selects the keys to read.
+// DB is the database read from, or written to, RDB files.
+// AllDBs reads all the databases of RDB files, recording them in the
+// Payloads, and writes each Payload to its database.
+// Append appends to an existing file instead of truncating it.
+// Checkpoint, if set, tracks the progress of writes.
+// Codec, if set, compresses written files. Read files are decompressed
+// by the Codec detected from their magic bytes.
+// Key, if set, encrypts written files, after compression, with AES-256-GCM.
+// Encrypted files can only be read with it.
+// Source and SourceDB are the source URI, without credentials, and DB
+// recorded in the Header of written Rump files.
+// Complete, if set, reports whether the source was completely read once
+// the message bus is closed, for the Trailer to be written.
+// Log is where progress and status messages are printed, os.Stdout by
+// default.
 type File struct {
-	Path   string
-	Bus    message.Bus
-	Silent bool
-	TTL    bool
+	Path       string
+	Format     string
+	Bus        message.Bus
+	Silent     bool
+	TTL        bool
+	Filter     filter.Filter
+	DB         int
+	AllDBs     bool
+	Append     bool
+	Checkpoint *checkpoint.Checkpoint
+	Codec      codec.Codec
+	Key        []byte
+	Source     string
+	SourceDB   int
+	Complete   func() bool
+	Log        io.Writer
 }
 
 // New creates the File struct, to be used for reading/writing.
 func New(path string, bus message.Bus, silent, ttl bool) *File {
 	return &File{
 		Path:   path,
+		Format: Rump,
 		Bus:    bus,
 		Silent: silent,
 		TTL:    ttl,
+		Log:    os.Stdout,
 	}
 }
 
@@ -33,66 +87,367 @@ func (f *File) maybeLog(s string) {
 	if f.Silent {
 		return
 	}
-	fmt.Print(s)
+	fmt.Fprint(f.Log, s)
 }
 
-// Read scans a Rump file and sends Payloads to the message bus.
+// Read scans a Rump, RDB, RESP or NDJSON file and sends Payloads to the message bus.
+// Compressed files are decompressed by the Codec detected from their magic bytes,
+// encrypted files decrypted with Key. With a Key, files must be encrypted.
 func (f *File) Read(ctx context.Context) error {
 	defer close(f.Bus)
 
-	d, err := os.Open(f.Path)
+	d := os.Stdin
+	if f.Path != Stdio {
+		var err error
+		if d, err = os.Open(f.Path); err != nil {
+			return err
+		}
+		defer d.Close()
+	}
+
+	br := bufio.NewReader(d)
+	if encrypted(br) {
+		if f.Key == nil {
+			return fmt.Errorf("file: %s is encrypted, a key is required", f.Path)
+		}
+		dr, err := newDecryptReader(br, f.Key)
+		if err != nil {
+			return err
+		}
+		br = bufio.NewReader(dr)
+	} else if f.Key != nil {
+		return fmt.Errorf("file: %s isn't encrypted, but a key is set", f.Path)
+	}
+
+	c, err := codec.Detect(br)
 	if err != nil {
 		return err
 	}
-	defer d.Close()
+	var r io.Reader = br
+	if c != nil {
+		cr, err := c.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer cr.Close()
+		r = cr
+	}
+
+	switch f.Format {
+	case RDB:
+		return f.readRDB(ctx, r)
+	case RESP:
+		return f.readRESP(ctx, r)
+	case NDJSON:
+		return f.readNDJSON(ctx, r)
+	}
+
+	return f.readRump(ctx, r)
+}
 
-	fmt.Println(f.Path)
-	prdr := gogoio.NewDelimitedReader(d, 1024*1024*600)
+// readRump reads a Rump file, verifying its Trailer.
+// Legacy files without Header are read with a warning, as their
+// completeness can't be verified.
+func (f *File) readRump(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		f.maybeLog("file read: legacy file without header, its completeness can't be verified\n")
+	}
 
+	m := newManifest()
 	for {
-		msg := &message.Payload{}
-		if err := prdr.ReadMsg(msg); err != nil {
-			if err == io.EOF {
-				break
+		b, err := readRecord(br, m)
+		if err == io.EOF {
+			if h != nil {
+				return fmt.Errorf("file: %s is truncated, its trailer is missing", f.Path)
 			}
+			return nil
+		}
+		if err != nil {
 			return err
 		}
+		if b == nil && h != nil {
+			return checkTrailer(br, m)
+		}
 
-		select {
-		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("file read: exit " + ctx.Err().Error())
-			return ctx.Err()
-		case f.Bus <- *msg:
-			f.maybeLog("r")
+		msg := &message.Payload{}
+		if err := msg.Unmarshal(b); err != nil {
+			return err
+		}
+		m.keys++
+
+		if !f.Filter.MatchKey(msg.Key) || !f.Filter.MatchType(rdb.DumpType(msg.Value)) {
+			continue
+		}
+
+		if err := f.send(ctx, *msg); err != nil {
+			return err
 		}
 	}
+}
+
+// readRecord reads a record, adding it to m, and returns its Payload bytes.
+// It returns io.EOF at the end of r, io.ErrUnexpectedEOF for a truncated
+// record, and nil for the zero length record preceding the Trailer.
+func readRecord(r *bufio.Reader, m *manifest) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("file: record size %d too large", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	varint := make([]byte, binary.MaxVarintLen64)
+	m.Write(varint[:binary.PutUvarint(varint, n)])
+	m.Write(b)
+	return b, nil
+}
+
+// send sends a Payload to the message bus, unless ctx is done.
+func (f *File) send(ctx context.Context, p message.Payload) error {
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(f.Log)
+		fmt.Fprintln(f.Log, "file read: exit "+ctx.Err().Error())
+		return ctx.Err()
+	case f.Bus <- p:
+		f.maybeLog("r")
+	}
 
 	return nil
 }
 
-// Write writes to a Rump file Payloads from the message bus.
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// scan reads the Header and the complete records of a Rump file,
+// and returns their size, a last record truncated by an interrupted write,
+// the Trailer and what follows being excluded.
+// The manifest of the records is nil for legacy files.
+func scan(r io.Reader) (int64, *manifest, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	size := func() int64 { return cr.n - int64(br.Buffered()) }
+
+	h, err := readHeader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	m := newManifest()
+	valid := size()
+
+	for {
+		b, err := readRecord(br, m)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if b == nil && h != nil {
+			break
+		}
+		m.keys++
+		valid = size()
+	}
+
+	// Empty files are written as new ones.
+	if h == nil && valid > 0 {
+		m = nil
+	}
+	return valid, m, nil
+}
+
+// openAppend opens the Rump file to append to it, creating it if missing.
+// A last record truncated by an interrupted write, or the Trailer of a
+// complete file, is dropped. It returns the manifest of the records to
+// complete, nil for legacy files appended without Trailer.
+func (f *File) openAppend() (*os.File, *manifest, error) {
+	d, err := os.OpenFile(f.Path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size, m, err := scan(d)
+	if err == nil {
+		err = d.Truncate(size)
+	}
+	if err == nil {
+		_, err = d.Seek(size, io.SeekStart)
+	}
+	if err == nil && size == 0 {
+		err = writeHeader(d, f.header())
+	}
+	if err != nil {
+		d.Close()
+		return nil, nil, err
+	}
+
+	return d, m, nil
+}
+
+// Write writes to a Rump, RDB, RESP or NDJSON file Payloads from the message bus.
+// With Append, Payloads are appended to an existing Rump file.
+// With a Checkpoint, written keys are confirmed once flushed to the file.
+// With a Codec, the file is compressed, with a Key encrypted.
 func (f *File) Write(ctx context.Context) error {
-	d, err := os.Create(f.Path)
+	if f.Append && (f.Codec != nil || f.Key != nil || f.Path == Stdio) {
+		return fmt.Errorf("file: can't append to compressed or encrypted files, or stdout")
+	}
+
+	var d *os.File
+	var m *manifest
+	var err error
+	switch {
+	case f.Path == Stdio:
+		d = os.Stdout
+	case f.Append:
+		d, m, err = f.openAppend()
+	default:
+		d, err = os.Create(f.Path)
+	}
 	if err != nil {
 		return err
 	}
-	defer d.Close()
+	if f.Path != Stdio {
+		defer d.Close()
+	}
+
+	// Layers closed in order, flushing their trailers.
+	var w io.Writer = d
+	var closers []io.Closer
+	if f.Key != nil {
+		ew, err := newEncryptWriter(w, f.Key)
+		if err != nil {
+			return err
+		}
+		w = ew
+		closers = append([]io.Closer{ew}, closers...)
+	}
+	if f.Codec != nil {
+		cw, err := f.Codec.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		w = cw
+		closers = append([]io.Closer{cw}, closers...)
+	}
+
+	// Failed or interrupted writes aren't closed, so that incomplete files
+	// lack their trailers and fail to be read, instead of passing as complete.
+	if err := f.write(ctx, w, m); err != nil {
+		return err
+	}
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the file in its Format, Rump files appended to with
+// the manifest of their records.
+func (f *File) write(ctx context.Context, d io.Writer, m *manifest) error {
+	switch f.Format {
+	case RDB:
+		return f.writeRDB(ctx, d)
+	case RESP:
+		return f.writeRESP(ctx, d)
+	case NDJSON:
+		return f.writeNDJSON(ctx, d)
+	}
 
+	return f.writeRump(ctx, d, m)
+}
+
+// header returns the Header of the Rump files written.
+func (f *File) header() Header {
+	return Header{
+		Version: FormatVersion,
+		Source:  f.Source,
+		DB:      f.SourceDB,
+		Start:   time.Now().UTC(),
+		TTL:     f.TTL,
+	}
+}
+
+// writeRump writes a Rump file, its Header unless appended to, and its
+// Trailer once the message bus is closed and Complete. Appended files
+// are completed with the manifest m of their records, legacy ones have
+// no Trailer.
+func (f *File) writeRump(ctx context.Context, d io.Writer, m *manifest) error {
 	// Buffered write to limit system IO calls
 	w := bufio.NewWriter(d)
-	wp := gogoio.NewDelimitedWriter(w)
+	if !f.Append {
+		if err := writeHeader(w, f.header()); err != nil {
+			return err
+		}
+		m = newManifest()
+	}
+	var records io.Writer = w
+	if m != nil {
+		records = io.MultiWriter(w, m)
+	}
+	wp := gogoio.NewDelimitedWriter(records)
+
+	// Keys written since the last flush.
+	var written []string
+	flush := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		f.Checkpoint.Confirm(written...)
+		written = written[:0]
+		return nil
+	}
 
 	// Flush last open buffers
-	defer w.Flush()
+	defer flush()
+
+	// Flush regularly to confirm keys to the Checkpoint.
+	var tick <-chan time.Time
+	if f.Checkpoint != nil {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		tick = t.C
+	}
 
 	for f.Bus != nil {
 		select {
 		// Exit early if context done.
 		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("file write: exit")
+			fmt.Fprintln(f.Log)
+			fmt.Fprintln(f.Log, "file write: exit")
 			return ctx.Err()
+		case <-tick:
+			if err := flush(); err != nil {
+				return err
+			}
 		// Get Messages from Bus
 		case p, ok := <-f.Bus:
 			// if channel closed, set to nil, break loop
@@ -103,9 +458,20 @@ func (f *File) Write(ctx context.Context) error {
 			if err := wp.WriteMsg(&p); err != nil {
 				return err
 			}
+			if m != nil {
+				m.keys++
+			}
+			if f.Checkpoint != nil {
+				written = append(written, p.Key)
+			}
 			f.maybeLog("w")
 		}
 	}
 
-	return nil
+	if m != nil && (f.Complete == nil || f.Complete()) {
+		if err := writeTrailer(w, m.trailer()); err != nil {
+			return err
+		}
+	}
+	return flush()
 }
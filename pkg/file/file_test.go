@@ -6,14 +6,22 @@ package file_test
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/domwong/rump/pkg/codec"
 	"github.com/domwong/rump/pkg/file"
+	"github.com/domwong/rump/pkg/filter"
 	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
 	"github.com/domwong/rump/pkg/redis"
+	"github.com/domwong/rump/pkg/resp"
 	rredis "github.com/go-redis/redis/v8"
 )
 
@@ -105,3 +113,636 @@ func TestWriteRead(t *testing.T) {
 		t.Errorf("expected: %v, result: %v", expected, result)
 	}
 }
+
+// Test restoring a selection of keys from the rump dump written by TestWriteRead
+func TestReadFilter(t *testing.T) {
+	ctx := context.Background()
+	ch3 := make(message.Bus, 100)
+
+	source := file.New(path, ch3, false, false)
+	source.Filter = filter.Filter{
+		Include: []string{"key2*"},
+		Types:   []string{"string"},
+	}
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]bool{}
+	for p := range ch3 {
+		result[p.Key] = true
+	}
+
+	if !reflect.DeepEqual(result, map[string]bool{"key2": true, "key20": true}) {
+		t.Errorf("unexpected filtered keys: %v", result)
+	}
+}
+
+// Test appending to a rump dump interrupted in the middle of a record
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	appendPath := os.TempDir() + "/append.rump"
+	defer os.Remove(appendPath)
+
+	write := func(keys ...string) {
+		ch := make(message.Bus, len(keys))
+		for _, k := range keys {
+			ch <- message.Payload{Key: k, Value: "v"}
+		}
+		close(ch)
+
+		target := file.New(appendPath, ch, true, false)
+		target.Append = true
+		if err := target.Write(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+	}
+
+	write("a", "b")
+
+	// Simulate a record truncated by an interrupted write.
+	d, _ := os.OpenFile(appendPath, os.O_APPEND|os.O_WRONLY, 0666)
+	d.Write([]byte{20, 0x0a})
+	d.Close()
+
+	write("c")
+
+	ch := make(message.Bus, 10)
+	source := file.New(appendPath, ch, true, false)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var result []string
+	for p := range ch {
+		result = append(result, p.Key)
+	}
+	if !reflect.DeepEqual(result, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected keys: %v", result)
+	}
+}
+
+// Test the header and trailer of rump files, and reading legacy files
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+	manifestPath := os.TempDir() + "/manifest.rump"
+	defer os.Remove(manifestPath)
+
+	write := func(complete bool) {
+		ch := make(message.Bus, 2)
+		ch <- message.Payload{Key: "a", Value: "va"}
+		ch <- message.Payload{Key: "b", Value: "vb"}
+		close(ch)
+
+		target := file.New(manifestPath, ch, true, true)
+		target.Source = "redis://s:6379/2"
+		target.SourceDB = 2
+		target.Complete = func() bool { return complete }
+		if err := target.Write(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+	}
+	read := func() ([]string, error) {
+		ch := make(message.Bus, 10)
+		source := file.New(manifestPath, ch, true, true)
+		err := source.Read(ctx)
+		var keys []string
+		for p := range ch {
+			keys = append(keys, p.Key)
+		}
+		return keys, err
+	}
+
+	write(true)
+	b, _ := ioutil.ReadFile(manifestPath)
+	if !strings.HasPrefix(string(b), "RUMP\x01") || !strings.Contains(string(b), `"source":"redis://s:6379/2","db":2`) {
+		t.Errorf("unexpected header: %q", b)
+	}
+	if keys, err := read(); err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("unexpected keys %v, error %v", keys, err)
+	}
+
+	// Tampered records don't match the trailer.
+	ioutil.WriteFile(manifestPath, []byte(strings.Replace(string(b), "vb", "vc", 1)), 0666)
+	if _, err := read(); err == nil || !strings.Contains(err.Error(), "trailer mismatch") {
+		t.Errorf("expected a trailer mismatch, got %v", err)
+	}
+
+	// Incomplete syncs have no trailer.
+	write(false)
+	if keys, err := read(); err == nil || !strings.Contains(err.Error(), "truncated") || len(keys) != 2 {
+		t.Errorf("expected a truncated file, got keys %v, error %v", keys, err)
+	}
+
+	// Legacy files are only records.
+	ioutil.WriteFile(manifestPath, []byte("\x05\x0a\x01a\x12\x00"), 0666)
+	if keys, err := read(); err != nil || !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("unexpected legacy keys %v, error %v", keys, err)
+	}
+}
+
+// rdbFile writes an RDB file of version 9 with a valid checksum.
+func rdbFile(t *testing.T, path string, body ...string) {
+	b := []byte("REDIS0009")
+	for _, s := range body {
+		b = append(b, s...)
+	}
+	b = append(b, 0xff)
+
+	var crc [8]byte
+	binary.LittleEndian.PutUint64(crc[:], rdb.Checksum(0, b))
+	if err := ioutil.WriteFile(path, append(b, crc[:]...), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test reading the keys of a DB of an RDB file, and restoring them
+func TestReadRDB(t *testing.T) {
+	ctx := context.Background()
+	rdbPath := os.TempDir() + "/dump.rdb"
+	defer os.Remove(rdbPath)
+
+	ms := func(d time.Duration) string {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(time.Now().Add(d).UnixNano()/int64(time.Millisecond)))
+		return "\xfc" + string(b[:])
+	}
+	rdbFile(t, rdbPath,
+		"\xfa\x09redis-ver\x056.2.0",
+		"\xfe\x00\xfb\x05\x02",
+		"\x00\x04rdb1\x06value1",
+		ms(time.Hour)+"\x00\x04rdb2\x06value2",
+		ms(-time.Hour)+"\x00\x07expired\x01v",
+		"\x01\x04list\x02\x01a\x01b",
+		"\x04\x04hash\x01\x01f\x01v",
+		"\xfe\x01",
+		"\x00\x05other\x01v",
+	)
+
+	read := func(db int, f filter.Filter) map[string]message.Payload {
+		ch := make(message.Bus, 10)
+		source := file.New(rdbPath, ch, false, true)
+		source.Format = file.RDB
+		source.DB = db
+		source.Filter = f
+		if err := source.Read(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+
+		result := map[string]message.Payload{}
+		for p := range ch {
+			result[p.Key] = p
+		}
+		return result
+	}
+
+	result := read(0, filter.Filter{})
+	if len(result) != 4 || result["rdb1"].Ttl != "0" || result["rdb2"].Ttl == "0" {
+		t.Errorf("unexpected keys: %v", result)
+	}
+	if rdb.DumpType(result["list"].Value) != "list" || rdb.DumpType(result["hash"].Value) != "hash" {
+		t.Errorf("unexpected types: %v", result)
+	}
+	if result := read(1, filter.Filter{}); len(result) != 1 || result["other"].Key != "other" {
+		t.Errorf("unexpected db 1 keys: %v", result)
+	}
+
+	// Restore the strings.
+	ch := make(message.Bus, 10)
+	source := file.New(rdbPath, ch, false, true)
+	source.Format = file.RDB
+	source.Filter = filter.Filter{Types: []string{"string"}}
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := redis.New(db2, ch, false, true)
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if db2.Get(ctx, "rdb1").Val() != "value1" || db2.Get(ctx, "rdb2").Val() != "value2" {
+		t.Error("rdb strings not restored")
+	}
+	if ttl := db2.PTTL(ctx, "rdb2").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("unexpected ttl %s", ttl)
+	}
+}
+
+// Test writing and reading all the databases of an RDB file
+func TestRDBAllDBs(t *testing.T) {
+	ctx := context.Background()
+	rdbPath := os.TempDir() + "/all.rdb"
+	defer os.Remove(rdbPath)
+
+	v := rdb.Dump(rdb.TypeString, []byte("\x01v"), 9)
+	ch := make(message.Bus, 3)
+	ch <- message.Payload{Key: "a", Value: v, Db: 2}
+	ch <- message.Payload{Key: "b", Value: v, Db: 2}
+	ch <- message.Payload{Key: "c", Value: v, Db: 5}
+	close(ch)
+
+	target := file.New(rdbPath, ch, true, false)
+	target.Format = file.RDB
+	target.AllDBs = true
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	ch = make(message.Bus, 10)
+	source := file.New(rdbPath, ch, true, false)
+	source.Format = file.RDB
+	source.AllDBs = true
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]int32{}
+	for p := range ch {
+		result[p.Key] = p.Db
+	}
+	if !reflect.DeepEqual(result, map[string]int32{"a": 2, "b": 2, "c": 5}) {
+		t.Errorf("unexpected keys databases: %v", result)
+	}
+}
+
+// Test writing db1 to an RDB file, and restoring it to db2
+func TestWriteRDB(t *testing.T) {
+	ctx := context.Background()
+	rdbPath := os.TempDir() + "/write.rdb"
+	defer os.Remove(rdbPath)
+	db2.FlushDB(ctx)
+	db1.Set(ctx, "expiring", "value", time.Hour)
+	defer db1.Del(ctx, "expiring")
+
+	ch := make(message.Bus, 100)
+	source := redis.New(db1, ch, false, true)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := file.New(rdbPath, ch, false, true)
+	target.Format = file.RDB
+	target.DB = 3
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	ch = make(message.Bus, 100)
+	source2 := file.New(rdbPath, ch, false, true)
+	source2.Format = file.RDB
+	source2.DB = 3
+	if err := source2.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target2 := redis.New(db2, ch, false, true)
+	if err := target2.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]string{}
+	for k := range expected {
+		result[k] = db2.Get(ctx, k).Val()
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+	if ttl := db2.PTTL(ctx, "expiring").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("unexpected ttl %s", ttl)
+	}
+}
+
+// Test a DUMP payload newer than the rdb file version is refused
+func TestWriteRDBNewerVersion(t *testing.T) {
+	rdbPath := os.TempDir() + "/newer.rdb"
+	defer os.Remove(rdbPath)
+
+	ch := make(message.Bus, 2)
+	ch <- message.Payload{Key: "old", Value: rdb.Dump(rdb.TypeString, []byte("\x01v"), 9), Ttl: "0"}
+	ch <- message.Payload{Key: "new", Value: rdb.Dump(rdb.TypeString, []byte("\x01v"), 10), Ttl: "0"}
+	close(ch)
+
+	target := file.New(rdbPath, ch, false, true)
+	target.Format = file.RDB
+	if err := target.Write(context.Background()); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected a newer version error, got %v", err)
+	}
+}
+
+// Test a key read twice is written once to rdb files
+func TestWriteRDBDuplicate(t *testing.T) {
+	ctx := context.Background()
+	rdbPath := os.TempDir() + "/duplicate.rdb"
+	defer os.Remove(rdbPath)
+
+	ch := make(message.Bus, 3)
+	for _, k := range []string{"k", "other", "k"} {
+		ch <- message.Payload{Key: k, Value: rdb.Dump(rdb.TypeString, []byte("\x01v"), 9), Ttl: "0"}
+	}
+	close(ch)
+	target := file.New(rdbPath, ch, true, false)
+	target.Format = file.RDB
+	if err := target.Write(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ch = make(message.Bus, 10)
+	source := file.New(rdbPath, ch, true, false)
+	source.Format = file.RDB
+	if err := source.Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for p := range ch {
+		keys = append(keys, p.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"k", "other"}) {
+		t.Errorf("unexpected keys %q", keys)
+	}
+}
+
+// Test writing db1 to a RESP file, and restoring it to db2
+func TestWriteReadRESP(t *testing.T) {
+	ctx := context.Background()
+	respPath := os.TempDir() + "/dump.resp"
+	defer os.Remove(respPath)
+	db2.FlushDB(ctx)
+	db1.Set(ctx, "expiring", "value", time.Hour)
+	defer db1.Del(ctx, "expiring")
+
+	ch := make(message.Bus, 100)
+	source := redis.New(db1, ch, false, true)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := file.New(respPath, ch, false, true)
+	target.Format = file.RESP
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	b, _ := ioutil.ReadFile(respPath)
+	if !strings.Contains(string(b), "*3\r\n$9\r\nPEXPIREAT\r\n$8\r\nexpiring\r\n") {
+		t.Error("expire time not written")
+	}
+
+	ch = make(message.Bus, 100)
+	source2 := file.New(respPath, ch, false, true)
+	source2.Format = file.RESP
+	if err := source2.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target2 := redis.New(db2, ch, false, true)
+	if err := target2.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]string{}
+	for k := range expected {
+		result[k] = db2.Get(ctx, k).Val()
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+	if ttl := db2.PTTL(ctx, "expiring").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("unexpected ttl %s", ttl)
+	}
+}
+
+// Test reading the transactions of logical copies from a RESP file,
+// filtered with the key of their commands
+func TestReadRESPTransaction(t *testing.T) {
+	ctx := context.Background()
+	respPath := os.TempDir() + "/tx.resp"
+	defer os.Remove(respPath)
+	var b []byte
+	for _, cmd := range [][]string{
+		{"MULTI"}, {"DEL", "list"}, {"RPUSH", "list", "a"}, {"EXEC"},
+		{"MULTI"}, {"DEL", "other"}, {"RPUSH", "other", "a"}, {"EXEC"},
+	} {
+		b = append(b, resp.Command(cmd...)...)
+	}
+	if err := ioutil.WriteFile(respPath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(message.Bus, 100)
+	source := file.New(respPath, ch, false, false)
+	source.Format = file.RESP
+	source.Filter = filter.Filter{Include: []string{"list"}}
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var keys []string
+	for p := range ch {
+		keys = append(keys, p.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"list", "list", "list", "list"}) {
+		t.Errorf("unexpected keys %q", keys)
+	}
+}
+
+// Test writing db1 to an NDJSON file, and restoring it to db2
+func TestWriteReadNDJSON(t *testing.T) {
+	ctx := context.Background()
+	jsonPath := os.TempDir() + "/dump.ndjson"
+	defer os.Remove(jsonPath)
+	db2.FlushDB(ctx)
+
+	ch := make(message.Bus, 100)
+	source := redis.New(db1, ch, false, false)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := file.New(jsonPath, ch, false, false)
+	target.Format = file.NDJSON
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	b, _ := ioutil.ReadFile(jsonPath)
+	if !strings.Contains(string(b), `{"key":"key1","type":"string","value":"value1"}`) {
+		t.Errorf("unexpected records: %s", b)
+	}
+
+	ch = make(message.Bus, 100)
+	source2 := file.New(jsonPath, ch, false, false)
+	source2.Format = file.NDJSON
+	if err := source2.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target2 := redis.New(db2, ch, false, false)
+	if err := target2.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]string{}
+	for k := range expected {
+		result[k] = db2.Get(ctx, k).Val()
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test importing logical values of every type with native commands
+func TestReadNDJSON(t *testing.T) {
+	ctx := context.Background()
+	jsonPath := os.TempDir() + "/import.ndjson"
+	defer os.Remove(jsonPath)
+	db2.FlushDB(ctx)
+
+	expireAt := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	lines := []string{
+		`{"key":"list","type":"list","value":["a","b"]}`,
+		`{"key":"hash","type":"hash","value":{"f":"v"},"ttl":60000}`,
+		`{"key":"zset","type":"zset","value":[{"member":"m","score":1.5}]}`,
+		`{"key":"stream","type":"stream","value":[{"id":"5-1","fields":["f","v"]}]}`,
+		fmt.Sprintf(`{"key":"set","type":"set","value":["x"],"expire_at":%d}`, expireAt),
+		`{"key":"expired","type":"string","value":"v","expire_at":1}`,
+	}
+	if err := ioutil.WriteFile(jsonPath, []byte(strings.Join(lines, "\n")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(message.Bus, 100)
+	source := file.New(jsonPath, ch, false, true)
+	source.Format = file.NDJSON
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := redis.New(db2, ch, false, true)
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	if l := db2.LRange(ctx, "list", 0, -1).Val(); !reflect.DeepEqual(l, []string{"a", "b"}) {
+		t.Errorf("unexpected list %v", l)
+	}
+	if h := db2.HGetAll(ctx, "hash").Val(); !reflect.DeepEqual(h, map[string]string{"f": "v"}) {
+		t.Errorf("unexpected hash %v", h)
+	}
+	if s := db2.ZScore(ctx, "zset", "m").Val(); s != 1.5 {
+		t.Errorf("unexpected score %v", s)
+	}
+	if x := db2.XRange(ctx, "stream", "-", "+").Val(); len(x) != 1 || x[0].ID != "5-1" || x[0].Values["f"] != "v" {
+		t.Errorf("unexpected stream %v", x)
+	}
+	if ttl := db2.PTTL(ctx, "hash").Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("unexpected hash ttl %s", ttl)
+	}
+	if ttl := db2.PTTL(ctx, "set").Val(); ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("unexpected set ttl %s", ttl)
+	}
+	if db2.Exists(ctx, "expired").Val() != 0 {
+		t.Error("expired key restored")
+	}
+}
+
+// Test writing a compressed file, detected when read back
+func TestCompressed(t *testing.T) {
+	ctx := context.Background()
+	gzPath := os.TempDir() + "/dump.rump.gz"
+	defer os.Remove(gzPath)
+
+	gz, _ := codec.Get("gzip")
+	ch := make(message.Bus, 100)
+	source := redis.New(db1, ch, false, false)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := file.New(gzPath, ch, false, false)
+	target.Codec = gz
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	b, _ := ioutil.ReadFile(gzPath)
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		t.Fatal("file not compressed")
+	}
+
+	ch = make(message.Bus, 100)
+	source2 := file.New(gzPath, ch, false, false)
+	if err := source2.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	result := map[string]bool{}
+	for p := range ch {
+		result[p.Key] = true
+	}
+	if len(result) != len(expected) {
+		t.Errorf("unexpected keys: %v", result)
+	}
+}
+
+// Test writing an encrypted and compressed file, failing to read it
+// with a wrong key or once tampered with
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	encPath := os.TempDir() + "/dump.rump.gz.enc"
+	defer os.Remove(encPath)
+	key := []byte(strings.Repeat("k", file.KeySize))
+
+	gz, _ := codec.Get("gzip")
+	ch := make(message.Bus, 100)
+	source := redis.New(db1, ch, false, false)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	target := file.New(encPath, ch, false, false)
+	target.Codec = gz
+	target.Key = key
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	read := func(key []byte) (int, error) {
+		ch := make(message.Bus, 100)
+		source := file.New(encPath, ch, true, false)
+		source.Key = key
+		err := source.Read(ctx)
+		n := 0
+		for range ch {
+			n++
+		}
+		return n, err
+	}
+
+	if n, err := read(key); err != nil || n != len(expected) {
+		t.Errorf("unexpected %d keys read, error %v", n, err)
+	}
+	if _, err := read(nil); err == nil {
+		t.Error("encrypted file read without key")
+	}
+	if _, err := read([]byte(strings.Repeat("w", file.KeySize))); err != file.ErrDecrypt {
+		t.Errorf("expected a decryption error, got %v", err)
+	}
+
+	b, _ := ioutil.ReadFile(encPath)
+	b[len(b)-1] ^= 1
+	ioutil.WriteFile(encPath, b, 0666)
+	if _, err := read(key); err != file.ErrDecrypt {
+		t.Errorf("expected a decryption error, got %v", err)
+	}
+
+	ioutil.WriteFile(encPath, b[:len(b)-30], 0666)
+	if _, err := read(key); err != file.ErrDecrypt {
+		t.Errorf("expected a decryption error, got %v", err)
+	}
+
+	// An interrupted write isn't sealed as complete.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	target = file.New(encPath, make(message.Bus), true, false)
+	target.Key = key
+	if err := target.Write(cctx); err != context.Canceled {
+		t.Errorf("expected a cancellation, got %v", err)
+	}
+	if _, err := read(key); err != file.ErrDecrypt {
+		t.Errorf("expected a decryption error, got %v", err)
+	}
+
+	ioutil.WriteFile(encPath, []byte("plain"), 0666)
+	if _, err := read(key); err == nil {
+		t.Error("plaintext file read with a key")
+	}
+}
@@ -0,0 +1,159 @@
+package file
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Rump files start with rumpMagic, the format version and a JSON Header,
+// followed by the records of the Payloads, then a zero length record
+// and a JSON Trailer. Records are the varint size and the Payload.
+// Legacy files have no header, and only records.
+const (
+	rumpMagic = "RUMP"
+	// FormatVersion is the version of the Rump files written.
+	FormatVersion = 1
+	// maxRecordSize is the maximum size of records read.
+	maxRecordSize = 600 * 1024 * 1024
+)
+
+// Header describes the sync writing a Rump file: its Source, without
+// credentials, the DB read, its Start time and whether TTLs were synced.
+type Header struct {
+	Version int       `json:"version"`
+	Source  string    `json:"source"`
+	DB      int       `json:"db"`
+	Start   time.Time `json:"start"`
+	TTL     bool      `json:"ttl"`
+}
+
+// Trailer closes a complete Rump file, with the number of Keys, counting
+// each of their Payloads, and the number of Bytes and SHA-256 Checksum
+// of the records.
+type Trailer struct {
+	Keys     int64  `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"`
+}
+
+// manifest tracks the records of a Rump file, for its Trailer.
+type manifest struct {
+	keys  int64
+	bytes int64
+	hash  hash.Hash
+}
+
+func newManifest() *manifest {
+	return &manifest{hash: sha256.New()}
+}
+
+// Write adds record bytes.
+func (m *manifest) Write(p []byte) (int, error) {
+	m.bytes += int64(len(p))
+	return m.hash.Write(p)
+}
+
+func (m *manifest) trailer() Trailer {
+	return Trailer{
+		Keys:     m.keys,
+		Bytes:    m.bytes,
+		Checksum: hex.EncodeToString(m.hash.Sum(nil)),
+	}
+}
+
+// writeJSON writes v as a varint sized JSON document.
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	size := make([]byte, binary.MaxVarintLen64)
+	if _, err := w.Write(size[:binary.PutUvarint(size, uint64(len(b)))]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readJSON reads a varint sized JSON document into v.
+func readJSON(r *bufio.Reader, v interface{}) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if n > 1024*1024 {
+		return fmt.Errorf("file: invalid manifest size %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeHeader starts a Rump file.
+func writeHeader(w io.Writer, h Header) error {
+	if _, err := io.WriteString(w, rumpMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{FormatVersion}); err != nil {
+		return err
+	}
+	return writeJSON(w, h)
+}
+
+// readHeader reads the header of a Rump file, nil for legacy files.
+// Records can't start with rumpMagic, as Payloads start with a known field.
+func readHeader(r *bufio.Reader) (*Header, error) {
+	if b, _ := r.Peek(len(rumpMagic)); string(b) != rumpMagic {
+		return nil, nil
+	}
+	r.Discard(len(rumpMagic))
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("file: truncated header")
+	}
+	if version > FormatVersion {
+		return nil, fmt.Errorf("file: unsupported format version %d, newer than %d", version, FormatVersion)
+	}
+
+	h := &Header{}
+	if err := readJSON(r, h); err != nil {
+		return nil, fmt.Errorf("file: invalid header: %s", err)
+	}
+	return h, nil
+}
+
+// writeTrailer ends a Rump file with a zero length record and its Trailer.
+func writeTrailer(w io.Writer, t Trailer) error {
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	return writeJSON(w, t)
+}
+
+// checkTrailer reads the Trailer following the zero length record,
+// and compares it with the records read.
+func checkTrailer(r *bufio.Reader, m *manifest) error {
+	var t Trailer
+	if err := readJSON(r, &t); err != nil {
+		return fmt.Errorf("file: invalid trailer: %s", err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return fmt.Errorf("file: unexpected data after the trailer")
+	}
+
+	if read := m.trailer(); read != t {
+		return fmt.Errorf("file: trailer mismatch, %d keys and %d bytes read, %d keys and %d bytes written, checksum %s, read %s",
+			read.Keys, read.Bytes, t.Keys, t.Bytes, t.Checksum, read.Checksum)
+	}
+	return nil
+}
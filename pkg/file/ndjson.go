@@ -0,0 +1,218 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+)
+
+// record is a line of an NDJSON file.
+// Value is the logical value of Type, or Dump the base64 DUMP payload
+// of values without a JSON form. TTL and ExpireAt are in milliseconds.
+// Deleted and Command records are deletions and commands.
+type record struct {
+	Key      string          `json:"key"`
+	Type     string          `json:"type,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Dump     []byte          `json:"dump,omitempty"`
+	TTL      int64           `json:"ttl,omitempty"`
+	ExpireAt int64           `json:"expire_at,omitempty"`
+	Deleted  bool            `json:"deleted,omitempty"`
+	Command  []string        `json:"command,omitempty"`
+}
+
+// newRecord returns the record of a Payload, decoding its DUMP payload.
+func newRecord(p message.Payload) (*record, error) {
+	rec := &record{Key: p.Key, Deleted: p.Deleted, Command: p.Command}
+	if p.Deleted || len(p.Command) > 0 {
+		return rec, nil
+	}
+
+	rec.TTL, _ = strconv.ParseInt(p.Ttl, 10, 64)
+	rec.ExpireAt = p.ExpireAt
+	rec.Type = rdb.DumpType(p.Value)
+
+	v, err := rdb.Decode(p.Value)
+	if err != nil || !textual(v) {
+		rec.Dump = []byte(p.Value)
+		return rec, nil
+	}
+
+	var value interface{}
+	switch v.Type {
+	case "string":
+		value = v.String
+	case "list", "set":
+		value = v.Elements
+	case "hash":
+		value = v.Hash
+	case "zset":
+		value = v.ZSet
+	case "stream":
+		value = v.Stream
+	}
+	rec.Value, err = json.Marshal(value)
+	return rec, err
+}
+
+// textual reports if a value can be written as JSON without loss:
+// strings must be valid UTF-8, and scores finite.
+func textual(v *rdb.Value) bool {
+	s := []string{v.String}
+	s = append(s, v.Elements...)
+	for field, value := range v.Hash {
+		s = append(s, field, value)
+	}
+	for _, m := range v.ZSet {
+		if math.IsInf(m.Score, 0) || math.IsNaN(m.Score) {
+			return false
+		}
+		s = append(s, m.Member)
+	}
+	for _, e := range v.Stream {
+		s = append(s, e.Fields...)
+	}
+
+	for _, str := range s {
+		if !utf8.ValidString(str) {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns the logical value of a record.
+func (rec *record) value() (*rdb.Value, error) {
+	v := &rdb.Value{Type: rec.Type}
+	var dst interface{}
+	switch rec.Type {
+	case "string":
+		dst = &v.String
+	case "list", "set":
+		dst = &v.Elements
+	case "hash":
+		dst = &v.Hash
+	case "zset":
+		dst = &v.ZSet
+	case "stream":
+		dst = &v.Stream
+	default:
+		return nil, fmt.Errorf("ndjson: key %s: unknown type %q", rec.Key, rec.Type)
+	}
+
+	if err := json.Unmarshal(rec.Value, dst); err != nil {
+		return nil, fmt.Errorf("ndjson: key %s: %s", rec.Key, err)
+	}
+	return v, nil
+}
+
+// readNDJSON reads an NDJSON file. Logical values are rebuilt with
+// native commands, DEL then the commands of their type and PEXPIREAT
+// or PEXPIRE with TTL, sent as command Payloads of the key.
+// Keys already expired are skipped.
+func (f *File) readNDJSON(ctx context.Context, d io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(d, 1024*1024))
+
+	for {
+		var rec record
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("ndjson: %s", err)
+		}
+
+		if !f.Filter.MatchKey(rec.Key) {
+			continue
+		}
+
+		var payloads []message.Payload
+		switch {
+		case rec.Deleted:
+			payloads = []message.Payload{{Key: rec.Key, Deleted: true}}
+		case len(rec.Command) > 0:
+			payloads = []message.Payload{{Key: rec.Key, Command: rec.Command}}
+		default:
+			if !f.Filter.MatchType(rec.Type) {
+				continue
+			}
+
+			ttl := rec.TTL
+			if rec.ExpireAt > 0 {
+				if ttl = rec.ExpireAt - time.Now().UnixNano()/int64(time.Millisecond); ttl <= 0 {
+					continue
+				}
+			}
+			if !f.TTL {
+				ttl, rec.ExpireAt = 0, 0
+			}
+
+			if rec.Dump != nil {
+				payloads = []message.Payload{{Key: rec.Key, Value: string(rec.Dump), Ttl: strconv.FormatInt(ttl, 10), ExpireAt: rec.ExpireAt}}
+				break
+			}
+
+			v, err := rec.value()
+			if err != nil {
+				return err
+			}
+			cmds := v.Commands(rec.Key)
+			if rec.ExpireAt > 0 {
+				cmds = append(cmds, []string{"PEXPIREAT", rec.Key, strconv.FormatInt(rec.ExpireAt, 10)})
+			} else if ttl > 0 {
+				cmds = append(cmds, []string{"PEXPIRE", rec.Key, strconv.FormatInt(ttl, 10)})
+			}
+			for _, cmd := range cmds {
+				payloads = append(payloads, message.Payload{Key: rec.Key, Command: cmd})
+			}
+		}
+
+		for _, p := range payloads {
+			if err := f.send(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeNDJSON writes the Payloads of the message bus as NDJSON records,
+// one per line.
+func (f *File) writeNDJSON(ctx context.Context, d io.Writer) error {
+	w := bufio.NewWriter(d)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	for f.Bus != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			fmt.Fprintln(f.Log)
+			fmt.Fprintln(f.Log, "file write: exit")
+			return ctx.Err()
+		case p, ok := <-f.Bus:
+			if !ok {
+				f.Bus = nil
+				continue
+			}
+
+			rec, err := newRecord(p)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			f.maybeLog("w")
+		}
+	}
+
+	return w.Flush()
+}
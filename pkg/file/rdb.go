@@ -0,0 +1,150 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+)
+
+// readRDB reads the keys of DB, or AllDBs, from an RDB file, each as
+// a DUMP Payload of the file RDB version. Keys already expired are skipped,
+// like Redis does loading the file.
+func (f *File) readRDB(ctx context.Context, d io.Reader) error {
+	r, err := rdb.NewReader(bufio.NewReaderSize(d, 1024*1024))
+	if err != nil {
+		return err
+	}
+
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if (e.DB != f.DB && !f.AllDBs) || !f.Filter.MatchKey(e.Key) || !f.Filter.MatchType(rdb.DumpType(e.Dump)) {
+			continue
+		}
+
+		p := message.Payload{Key: e.Key, Value: e.Dump, Ttl: "0"}
+		if f.AllDBs {
+			p.Db = int32(e.DB)
+		}
+		if e.ExpireAt > 0 {
+			ttl := e.ExpireAt - time.Now().UnixNano()/int64(time.Millisecond)
+			if ttl <= 0 {
+				continue
+			}
+			if f.TTL {
+				p.Ttl = strconv.FormatInt(ttl, 10)
+				p.ExpireAt = e.ExpireAt
+			}
+		}
+
+		if err := f.send(ctx, p); err != nil {
+			return err
+		}
+	}
+}
+
+// writeRDB writes the Payloads of the message bus as the keys of DB
+// of an RDB file, or with AllDBs of the database of each Payload.
+// The file RDB version is the version of the first DUMP payload, so that
+// Redis versions able to restore it can load the file: later payloads of a
+// newer version return an error, as Redis would refuse to load the file.
+// Keys already written to their database, read twice by SCAN, are
+// skipped: Redis refuses to load files with duplicate keys.
+// Expire times come from the Payloads TTL.
+func (f *File) writeRDB(ctx context.Context, d io.Writer) error {
+	bw := bufio.NewWriter(d)
+
+	var w *rdb.Writer
+	var version int
+	db := f.DB
+	written := map[int]map[string]struct{}{}
+	skipped := 0
+	start := func(v int) error {
+		var err error
+		version = v
+		if w, err = rdb.NewWriter(bw, version); err != nil {
+			return err
+		}
+		return w.SelectDB(db)
+	}
+
+	for f.Bus != nil {
+		select {
+		// Exit early if context done, leaving an incomplete file.
+		case <-ctx.Done():
+			fmt.Fprintln(f.Log)
+			fmt.Fprintln(f.Log, "file write: exit")
+			return ctx.Err()
+		case p, ok := <-f.Bus:
+			if !ok {
+				f.Bus = nil
+				continue
+			}
+			if p.Deleted || len(p.Command) > 0 {
+				return fmt.Errorf("rdb files can't store deletions or commands, key %s", p.Key)
+			}
+
+			if w == nil {
+				if f.AllDBs {
+					db = int(p.Db)
+				}
+				if err := start(rdb.DumpVersion(p.Value)); err != nil {
+					return err
+				}
+			} else if f.AllDBs && int(p.Db) != db {
+				db = int(p.Db)
+				if err := w.SelectDB(db); err != nil {
+					return err
+				}
+			}
+			if v := rdb.DumpVersion(p.Value); v > version {
+				return fmt.Errorf("rdb file version %d, key %s has a newer DUMP version %d", version, p.Key, v)
+			}
+			keys := written[db]
+			if keys == nil {
+				keys = map[string]struct{}{}
+				written[db] = keys
+			}
+			if _, ok := keys[p.Key]; ok {
+				skipped++
+				continue
+			}
+			keys[p.Key] = struct{}{}
+
+			var expireAt int64
+			if ttl, _ := strconv.ParseInt(p.Ttl, 10, 64); ttl > 0 {
+				expireAt = time.Now().UnixNano()/int64(time.Millisecond) + ttl
+			}
+			if err := w.Write(p.Key, p.Value, expireAt); err != nil {
+				return err
+			}
+			f.maybeLog("w")
+		}
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(f.Log, "\nrdb: %d duplicate keys skipped\n", skipped)
+	}
+	if w == nil {
+		if err := start(rdb.DefaultVersion); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,168 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+	"github.com/domwong/rump/pkg/resp"
+)
+
+// readRESP reads a file of RESP commands, as written by writeRESP.
+// RESTORE commands, with the PEXPIREAT following them, are read as DUMP
+// Payloads, DEL commands as deletions, other commands as commands,
+// with MULTI and EXEC given the key of the first command of their transaction.
+// Keys already expired are skipped.
+func (f *File) readRESP(ctx context.Context, d io.Reader) error {
+	br := bufio.NewReaderSize(d, 1024*1024)
+
+	// next is the command read after a RESTORE, looking for its PEXPIREAT,
+	// or after a MULTI, looking for the key of the transaction.
+	var next []string
+	// tx is the key of the open transaction, given to its MULTI and EXEC
+	// so that they're filtered and restored with its commands.
+	var tx string
+	for {
+		args := next
+		next = nil
+		if args == nil {
+			var err error
+			if args, _, err = resp.ReadCommand(br); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "restore":
+			if len(args) < 4 {
+				return fmt.Errorf("resp: wrong restore arguments %q", args)
+			}
+			p := message.Payload{Key: args[1], Value: args[3], Ttl: "0"}
+			if ttl, _ := strconv.ParseInt(args[2], 10, 64); ttl > 0 && f.TTL {
+				p.Ttl = args[2]
+			}
+
+			cmd, _, err := resp.ReadCommand(br)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if len(cmd) == 3 && strings.ToLower(cmd[0]) == "pexpireat" && cmd[1] == p.Key {
+				expireAt, err := strconv.ParseInt(cmd[2], 10, 64)
+				if err != nil {
+					return fmt.Errorf("resp: wrong pexpireat arguments %q", cmd)
+				}
+				ttl := expireAt - time.Now().UnixNano()/int64(time.Millisecond)
+				if ttl <= 0 {
+					continue
+				}
+				if f.TTL {
+					p.Ttl = strconv.FormatInt(ttl, 10)
+					p.ExpireAt = expireAt
+				}
+			} else {
+				next = cmd
+			}
+
+			if !f.Filter.MatchKey(p.Key) || !f.Filter.MatchType(rdb.DumpType(p.Value)) {
+				continue
+			}
+			if err := f.send(ctx, p); err != nil {
+				return err
+			}
+		case "del":
+			for _, key := range args[1:] {
+				if !f.Filter.MatchKey(key) {
+					continue
+				}
+				if err := f.send(ctx, message.Payload{Key: key, Deleted: true}); err != nil {
+					return err
+				}
+			}
+		default:
+			p := message.Payload{Command: args}
+			if len(args) > 1 {
+				p.Key = args[1]
+			}
+			switch strings.ToLower(args[0]) {
+			case "multi":
+				cmd, _, err := resp.ReadCommand(br)
+				if err != nil && err != io.EOF {
+					return err
+				}
+				tx = ""
+				if len(cmd) > 1 {
+					tx = cmd[1]
+				}
+				p.Key, next = tx, cmd
+			case "exec":
+				p.Key, tx = tx, ""
+			}
+			filtered := len(f.Filter.Include) > 0 || len(f.Filter.Exclude) > 0
+			if (p.Key == "" && filtered) || (p.Key != "" && !f.Filter.MatchKey(p.Key)) {
+				continue
+			}
+			if err := f.send(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeRESP writes the Payloads of the message bus as RESP commands,
+// to be piped to redis-cli --pipe: RESTORE with REPLACE for DUMP Payloads,
+// followed by PEXPIREAT for keys with a known expire time, so that
+// the expiration doesn't depend on when the file is loaded.
+func (f *File) writeRESP(ctx context.Context, d io.Writer) error {
+	w := bufio.NewWriter(d)
+
+	for f.Bus != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			fmt.Fprintln(f.Log)
+			fmt.Fprintln(f.Log, "file write: exit")
+			return ctx.Err()
+		case p, ok := <-f.Bus:
+			if !ok {
+				f.Bus = nil
+				continue
+			}
+
+			var cmds [][]string
+			switch {
+			case p.Deleted:
+				cmds = [][]string{{"DEL", p.Key}}
+			case len(p.Command) > 0:
+				cmds = [][]string{p.Command}
+			case p.ExpireAt > 0:
+				cmds = [][]string{
+					{"RESTORE", p.Key, "0", p.Value, "REPLACE"},
+					{"PEXPIREAT", p.Key, strconv.FormatInt(p.ExpireAt, 10)},
+				}
+			default:
+				ttl := p.Ttl
+				if ttl == "" {
+					ttl = "0"
+				}
+				cmds = [][]string{{"RESTORE", p.Key, ttl, p.Value, "REPLACE"}}
+			}
+
+			for _, cmd := range cmds {
+				if _, err := w.Write(resp.Command(cmd...)); err != nil {
+					return err
+				}
+			}
+			f.maybeLog("w")
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,156 @@
+// Package filter selects keys by name and type.
+package filter
+
+// Filter selects keys.
+// Include and Exclude are Redis glob-style patterns, as used by SCAN MATCH.
+// A key is selected if it matches any Include pattern, or Include is empty,
+// and it matches no Exclude pattern.
+// Types are Redis type names (string, list, set, zset, hash, stream),
+// a key is selected if its type is listed, or Types is empty.
+type Filter struct {
+	Include []string
+	Exclude []string
+	Types   []string
+}
+
+// MatchKey reports whether the Filter selects key by name.
+func (f Filter) MatchKey(key string) bool {
+	for _, p := range f.Exclude {
+		if Match(p, key) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if Match(p, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchType reports whether the Filter selects a key type.
+func (f Filter) MatchType(t string) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, ft := range f.Types {
+		if ft == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ScanMatch returns a pattern for SCAN MATCH, "" to match every key.
+// Only a single Include pattern can be delegated to the server.
+func (f Filter) ScanMatch() string {
+	if len(f.Include) != 1 {
+		return ""
+	}
+	return f.Include[0]
+}
+
+// ScanType returns a type for SCAN TYPE, "" to match every type.
+// Only a single type can be delegated to the server.
+func (f Filter) ScanType() string {
+	if len(f.Types) != 1 {
+		return ""
+	}
+	return f.Types[0]
+}
+
+// Match reports whether s matches the Redis glob-style pattern.
+// Supports *, ?, [abc], [^abc], [a-z] and \ escapes,
+// following Redis stringmatchlen.
+func Match(pattern, s string) bool {
+	p, i := 0, 0
+	for p < len(pattern) && i < len(s) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; i < len(s); i++ {
+				if Match(pattern[p+1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			i++
+		case '[':
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for {
+				if p >= len(pattern) {
+					// Unterminated class, the last char closes it.
+					p--
+					break
+				}
+				if pattern[p] == '\\' && p+1 < len(pattern) {
+					p++
+					if pattern[p] == s[i] {
+						match = true
+					}
+				} else if pattern[p] == ']' {
+					break
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					p += 2
+					if s[i] >= start && s[i] <= end {
+						match = true
+					}
+				} else if pattern[p] == s[i] {
+					match = true
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			i++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			if pattern[p] != s[i] {
+				return false
+			}
+			i++
+		default:
+			if pattern[p] != s[i] {
+				return false
+			}
+			i++
+		}
+		p++
+	}
+
+	// Trailing stars match the empty string.
+	if i == len(s) {
+		for p < len(pattern) && pattern[p] == '*' {
+			p++
+		}
+	}
+
+	return p == len(pattern) && i == len(s)
+}
@@ -0,0 +1,87 @@
+package filter
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"session:*", "session:1", true},
+		{"session:*", "sessions:1", false},
+		{"session:*:data", "session:1:data", true},
+		{"session:*:data", "session:1:meta", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"**a", "bba", true},
+		{"a*", "b", false},
+		{"abc", "abcd", false},
+		{"abcd", "abc", false},
+	}
+
+	for _, c := range cases {
+		if Match(c.pattern, c.s) != c.match {
+			t.Errorf("pattern %q on %q: expected %v", c.pattern, c.s, c.match)
+		}
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	f := Filter{
+		Include: []string{"session:*", "feature_flag:*"},
+		Exclude: []string{"session:tmp:*"},
+	}
+
+	for key, match := range map[string]bool{
+		"session:1":      true,
+		"feature_flag:a": true,
+		"session:tmp:1":  false,
+		"user:1":         false,
+	} {
+		if f.MatchKey(key) != match {
+			t.Errorf("key %s: expected %v", key, match)
+		}
+	}
+
+	if !(Filter{}).MatchKey("any") {
+		t.Error("empty filter should match every key")
+	}
+}
+
+func TestMatchType(t *testing.T) {
+	f := Filter{Types: []string{"hash", "set"}}
+
+	if !f.MatchType("hash") || !f.MatchType("set") || f.MatchType("string") {
+		t.Error("wrong type match")
+	}
+
+	if !(Filter{}).MatchType("string") {
+		t.Error("empty filter should match every type")
+	}
+}
+
+func TestScan(t *testing.T) {
+	f := Filter{Include: []string{"session:*"}, Types: []string{"hash"}}
+	if f.ScanMatch() != "session:*" || f.ScanType() != "hash" {
+		t.Error("single include and type should be delegated to SCAN")
+	}
+
+	f = Filter{Include: []string{"a*", "b*"}, Types: []string{"hash", "set"}}
+	if f.ScanMatch() != "" || f.ScanType() != "" {
+		t.Error("multiple includes and types should not be delegated to SCAN")
+	}
+}
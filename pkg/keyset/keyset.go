@@ -0,0 +1,53 @@
+// Package keyset tracks the keys seen during a sync.
+package keyset
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Set is a set of keys, safe for concurrent use.
+// It stores 64-bit hashes of the keys to bound memory on large DBs,
+// so Has may report a key as seen with a negligible probability.
+type Set struct {
+	mu     sync.Mutex
+	hashes map[uint64]struct{}
+}
+
+// New creates an empty Set.
+func New() *Set {
+	return &Set{
+		hashes: map[uint64]struct{}{},
+	}
+}
+
+// Hash returns the 64-bit FNV-1a hash of a key, by which keys are tracked.
+func Hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Add adds a key to the Set.
+func (s *Set) Add(key string) {
+	h := Hash(key)
+	s.mu.Lock()
+	s.hashes[h] = struct{}{}
+	s.mu.Unlock()
+}
+
+// Has reports whether the key was added to the Set.
+func (s *Set) Has(key string) bool {
+	h := Hash(key)
+	s.mu.Lock()
+	_, ok := s.hashes[h]
+	s.mu.Unlock()
+	return ok
+}
+
+// Len returns the number of keys in the Set.
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.hashes)
+}
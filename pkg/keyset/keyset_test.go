@@ -0,0 +1,24 @@
+package keyset
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	s := New()
+	for i := 0; i < 1000; i++ {
+		s.Add(fmt.Sprintf("key%d", i))
+	}
+	s.Add("key1")
+
+	if s.Len() != 1000 {
+		t.Errorf("expected 1000 keys, got %d", s.Len())
+	}
+	if !s.Has("key999") {
+		t.Error("key999 should be in the set")
+	}
+	if s.Has("key1000") {
+		t.Error("key1000 should not be in the set")
+	}
+}
@@ -0,0 +1,188 @@
+// Package merge merges the Payloads of several sources into a single
+// message bus, resolving the conflicts of keys read from more than one
+// source with a Policy.
+package merge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/domwong/rump/pkg/message"
+)
+
+// Policies resolving conflicts.
+const (
+	// FirstWins keeps the value of the first source listed.
+	FirstWins = "first-wins"
+	// LastWins keeps the value of the last source listed.
+	LastWins = "last-wins"
+	// LongestTTL keeps the value expiring last, persistent values first,
+	// the first source listed on ties. Expiring values must have absolute
+	// expire times, relative TTLs depending on when they were read.
+	LongestTTL = "longest-ttl"
+)
+
+// Policies lists the Policies.
+var Policies = []string{FirstWins, LastWins, LongestTTL}
+
+// written is the source and expire time of the value written for a key.
+type written struct {
+	source   int
+	expireAt int64
+}
+
+// Merge reads Payloads from the In message buses, one per source in the
+// order sources are listed, and sends them to the Out message bus.
+// The sources are read concurrently: a conflicting value is sent whenever
+// it wins over the value already sent for its key, replacing it, so that
+// the winner doesn't depend on the order values are read.
+// Conflicts are Counted. Keys are tracked exactly, a value is never
+// dropped for another key.
+// Log is where status messages are printed, os.Stdout by default.
+type Merge struct {
+	Policy string
+	In     []message.Bus
+	Out    message.Bus
+	Count  int
+	Log    io.Writer
+
+	// keys maps the keys to the values written.
+	keys map[key]written
+}
+
+// key is a key in its database.
+type key struct {
+	db  int32
+	key string
+}
+
+// New creates the Merge struct, to be run between the readers and a writer.
+func New(policy string, in []message.Bus, out message.Bus) *Merge {
+	return &Merge{
+		Policy: policy,
+		In:     in,
+		Out:    out,
+		Log:    os.Stdout,
+		keys:   map[key]written{},
+	}
+}
+
+// expireAt returns the expire time of p in milliseconds,
+// math.MaxInt64 for persistent values. It reports false for expiring
+// values without an absolute expire time.
+func expireAt(p message.Payload) (int64, bool) {
+	if p.ExpireAt > 0 {
+		return p.ExpireAt, true
+	}
+	ttl, _ := strconv.ParseInt(p.Ttl, 10, 64)
+	if ttl <= 0 {
+		return math.MaxInt64, true
+	}
+	return 0, false
+}
+
+// Payload reports whether p, read from source, must be sent: its key wasn't
+// sent yet, or its value wins the conflict with the value sent.
+// Values made of several commands, and with LongestTTL expiring values
+// without an absolute expire time, can't be merged and return an error.
+func (m *Merge) Payload(source int, p message.Payload) (bool, error) {
+	if len(p.Command) > 0 || p.Deleted {
+		return false, fmt.Errorf("merge: key %s: commands can't be merged, only values", p.Key)
+	}
+
+	k := key{db: p.Db, key: p.Key}
+	w := written{source: source}
+	if m.Policy == LongestTTL {
+		var ok bool
+		if w.expireAt, ok = expireAt(p); !ok {
+			return false, fmt.Errorf("merge: key %s: %s requires absolute expire times, missing from its source", p.Key, LongestTTL)
+		}
+	}
+	sent, ok := m.keys[k]
+	if !ok || sent.source == source {
+		m.keys[k] = w
+		return true, nil
+	}
+
+	m.Count++
+	wins := false
+	switch m.Policy {
+	case FirstWins:
+		wins = source < sent.source
+	case LastWins:
+		wins = source > sent.source
+	case LongestTTL:
+		wins = w.expireAt > sent.expireAt || (w.expireAt == sent.expireAt && source < sent.source)
+	}
+	if wins {
+		m.keys[k] = w
+	}
+	return wins, nil
+}
+
+// sourced is a Payload read from a source.
+type sourced struct {
+	source int
+	p      message.Payload
+}
+
+// Run merges the Payloads of the In buses until they're all closed,
+// then closes Out.
+func (m *Merge) Run(ctx context.Context) error {
+	defer close(m.Out)
+
+	all := make(chan sourced, len(m.In))
+	var wg sync.WaitGroup
+	for i, in := range m.In {
+		wg.Add(1)
+		go func(i int, in message.Bus) {
+			defer wg.Done()
+			for p := range in {
+				select {
+				case <-ctx.Done():
+					return
+				case all <- sourced{i, p}:
+				}
+			}
+		}(i, in)
+	}
+	go func() {
+		wg.Wait()
+		close(all)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(m.Log)
+			fmt.Fprintln(m.Log, "merge: exit")
+			return ctx.Err()
+		case s, ok := <-all:
+			if !ok {
+				fmt.Fprintf(m.Log, "merge: %d conflicts\n", m.Count)
+				return nil
+			}
+
+			send, err := m.Payload(s.source, s.p)
+			if err != nil {
+				return err
+			}
+			if !send {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				fmt.Fprintln(m.Log)
+				fmt.Fprintln(m.Log, "merge: exit")
+				return ctx.Err()
+			case m.Out <- s.p:
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package merge
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/domwong/rump/pkg/message"
+)
+
+func TestPayload(t *testing.T) {
+	a := message.Payload{Key: "k", Value: "a", Ttl: "1000", ExpireAt: 2000}
+	b := message.Payload{Key: "k", Value: "b", Ttl: "0"}
+
+	cases := []struct {
+		policy string
+		// Whether b, from the second source, wins when read first or last.
+		first, last bool
+	}{
+		{FirstWins, false, false},
+		{LastWins, true, true},
+		{LongestTTL, true, true},
+	}
+	for _, c := range cases {
+		m := New(c.policy, nil, nil)
+		if send, err := m.Payload(1, b); !send || err != nil {
+			t.Errorf("%s: the first value of a key should be sent, error %v", c.policy, err)
+		}
+		if send, _ := m.Payload(0, a); send == c.first {
+			t.Errorf("%s: unexpected winner read after b", c.policy)
+		}
+
+		m = New(c.policy, nil, nil)
+		m.Payload(0, a)
+		if send, _ := m.Payload(1, b); send != c.last || m.Count != 1 {
+			t.Errorf("%s: unexpected winner read after a, count %d", c.policy, m.Count)
+		}
+	}
+
+	m := New(LongestTTL, nil, nil)
+	m.Payload(0, a)
+	if send, _ := m.Payload(1, message.Payload{Key: "k", Value: "c", Ttl: "10", ExpireAt: 1010}); send {
+		t.Error("shorter ttl shouldn't win")
+	}
+	// Relative TTLs, read at different times, can't be compared.
+	if _, err := m.Payload(1, message.Payload{Key: "k", Value: "c", Ttl: "10"}); err == nil {
+		t.Error("relative ttls shouldn't be merged")
+	}
+	// The same key of another database isn't a conflict.
+	if send, _ := m.Payload(1, message.Payload{Key: "k", Value: "c", Db: 1}); !send || m.Count != 1 {
+		t.Errorf("unexpected conflict, count %d", m.Count)
+	}
+	if _, err := m.Payload(1, message.Payload{Key: "k", Command: []string{"RPUSH", "k", "a"}}); err == nil {
+		t.Error("commands shouldn't be merged")
+	}
+}
+
+func TestRun(t *testing.T) {
+	in := []message.Bus{make(message.Bus, 2), make(message.Bus, 2)}
+	out := make(message.Bus, 4)
+	in[0] <- message.Payload{Key: "a", Value: "0"}
+	in[0] <- message.Payload{Key: "b", Value: "0"}
+	in[1] <- message.Payload{Key: "a", Value: "1"}
+	close(in[0])
+	close(in[1])
+
+	var log bytes.Buffer
+	m := New(FirstWins, in, out)
+	m.Log = &log
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{}
+	for p := range out {
+		values[p.Key] = p.Value
+	}
+	if values["a"] != "0" || values["b"] != "0" || m.Count != 1 {
+		t.Errorf("unexpected values %v, count %d", values, m.Count)
+	}
+	if log.String() != "merge: 1 conflicts\n" {
+		t.Errorf("unexpected log %q", log.String())
+	}
+}
@@ -26,6 +26,10 @@ type Payload struct {
 	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
 	Value                string   `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
 	Ttl                  string   `protobuf:"bytes,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	ExpireAt             int64    `protobuf:"varint,4,opt,name=expire_at,json=expireAt,proto3" json:"expire_at,omitempty"`
+	Deleted              bool     `protobuf:"varint,5,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	Command              []string `protobuf:"bytes,6,rep,name=command,proto3" json:"command,omitempty"`
+	Db                   int32    `protobuf:"varint,7,opt,name=db,proto3" json:"db,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -85,6 +89,34 @@ func (m *Payload) GetTtl() string {
 	return ""
 }
 
+func (m *Payload) GetExpireAt() int64 {
+	if m != nil {
+		return m.ExpireAt
+	}
+	return 0
+}
+
+func (m *Payload) GetDeleted() bool {
+	if m != nil {
+		return m.Deleted
+	}
+	return false
+}
+
+func (m *Payload) GetCommand() []string {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+func (m *Payload) GetDb() int32 {
+	if m != nil {
+		return m.Db
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Payload)(nil), "message.Payload")
 }
@@ -92,15 +124,20 @@ func init() {
 func init() { proto.RegisterFile("payload.proto", fileDescriptor_678c914f1bee6d56) }
 
 var fileDescriptor_678c914f1bee6d56 = []byte{
-	// 121 bytes of a gzipped FileDescriptorProto
+	// 198 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2d, 0x48, 0xac, 0xcc,
 	0xc9, 0x4f, 0x4c, 0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0xcf, 0x4d, 0x2d, 0x2e, 0x4e,
-	0x4c, 0x4f, 0x55, 0x72, 0xe6, 0x62, 0x0f, 0x80, 0xc8, 0x08, 0x09, 0x70, 0x31, 0x67, 0xa7, 0x56,
-	0x4a, 0x30, 0x2a, 0x30, 0x6a, 0x70, 0x06, 0x81, 0x98, 0x42, 0x22, 0x5c, 0xac, 0x65, 0x89, 0x39,
-	0xa5, 0xa9, 0x12, 0x4c, 0x60, 0x31, 0x08, 0x07, 0xa4, 0xae, 0xa4, 0x24, 0x47, 0x82, 0x19, 0xa2,
-	0xae, 0xa4, 0x24, 0xc7, 0x49, 0xe0, 0xc4, 0x23, 0x39, 0xc6, 0x0b, 0x8f, 0xe4, 0x18, 0x1f, 0x3c,
-	0x92, 0x63, 0x9c, 0xf1, 0x58, 0x8e, 0x21, 0x89, 0x0d, 0x6c, 0x8d, 0x31, 0x20, 0x00, 0x00, 0xff,
-	0xff, 0x0d, 0x9e, 0x25, 0xe7, 0x77, 0x00, 0x00, 0x00,
+	0x4c, 0x4f, 0x55, 0x5a, 0xc2, 0xc8, 0xc5, 0x1e, 0x00, 0x91, 0x12, 0x12, 0xe0, 0x62, 0xce, 0x4e,
+	0xad, 0x94, 0x60, 0x54, 0x60, 0xd4, 0xe0, 0x0c, 0x02, 0x31, 0x85, 0x44, 0xb8, 0x58, 0xcb, 0x12,
+	0x73, 0x4a, 0x53, 0x25, 0x98, 0xc0, 0x62, 0x10, 0x0e, 0x48, 0x5d, 0x49, 0x49, 0x8e, 0x04, 0x33,
+	0x44, 0x5d, 0x49, 0x49, 0x8e, 0x90, 0x34, 0x17, 0x67, 0x6a, 0x45, 0x41, 0x66, 0x51, 0x6a, 0x7c,
+	0x62, 0x89, 0x04, 0x8b, 0x02, 0xa3, 0x06, 0x73, 0x10, 0x07, 0x44, 0xc0, 0xb1, 0x44, 0x48, 0x82,
+	0x8b, 0x3d, 0x25, 0x35, 0x27, 0xb5, 0x24, 0x35, 0x45, 0x82, 0x55, 0x81, 0x51, 0x83, 0x23, 0x08,
+	0xc6, 0x05, 0xc9, 0x24, 0xe7, 0xe7, 0xe6, 0x26, 0xe6, 0xa5, 0x48, 0xb0, 0x29, 0x30, 0x6b, 0x70,
+	0x06, 0xc1, 0xb8, 0x42, 0x7c, 0x5c, 0x4c, 0x29, 0x49, 0x12, 0xec, 0x0a, 0x8c, 0x1a, 0xac, 0x41,
+	0x4c, 0x29, 0x49, 0x4e, 0x02, 0x27, 0x1e, 0xc9, 0x31, 0x5e, 0x78, 0x24, 0xc7, 0xf8, 0xe0, 0x91,
+	0x1c, 0xe3, 0x8c, 0xc7, 0x72, 0x0c, 0x49, 0x6c, 0x60, 0x8f, 0x18, 0x03, 0x06, 0x00, 0x48, 0x3a,
+	0xc7, 0x6f, 0xd9, 0x00, 0x00, 0x00,
 }
 
 func (m *Payload) Marshal() (dAtA []byte, err error) {
@@ -127,6 +164,35 @@ func (m *Payload) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.Db != 0 {
+		i = encodeVarintPayload(dAtA, i, uint64(m.Db))
+		i--
+		dAtA[i] = 0x38
+	}
+	if len(m.Command) > 0 {
+		for iNdEx := len(m.Command) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Command[iNdEx])
+			copy(dAtA[i:], m.Command[iNdEx])
+			i = encodeVarintPayload(dAtA, i, uint64(len(m.Command[iNdEx])))
+			i--
+			dAtA[i] = 0x32
+		}
+	}
+	if m.Deleted {
+		i--
+		if m.Deleted {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.ExpireAt != 0 {
+		i = encodeVarintPayload(dAtA, i, uint64(m.ExpireAt))
+		i--
+		dAtA[i] = 0x20
+	}
 	if len(m.Ttl) > 0 {
 		i -= len(m.Ttl)
 		copy(dAtA[i:], m.Ttl)
@@ -180,6 +246,21 @@ func (m *Payload) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovPayload(uint64(l))
 	}
+	if m.ExpireAt != 0 {
+		n += 1 + sovPayload(uint64(m.ExpireAt))
+	}
+	if m.Deleted {
+		n += 2
+	}
+	if len(m.Command) > 0 {
+		for _, s := range m.Command {
+			l = len(s)
+			n += 1 + l + sovPayload(uint64(l))
+		}
+	}
+	if m.Db != 0 {
+		n += 1 + sovPayload(uint64(m.Db))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -317,6 +398,96 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 			}
 			m.Ttl = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExpireAt", wireType)
+			}
+			m.ExpireAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPayload
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ExpireAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Deleted", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPayload
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Deleted = bool(v != 0)
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Command", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPayload
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPayload
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPayload
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Command = append(m.Command, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Db", wireType)
+			}
+			m.Db = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPayload
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Db |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPayload(dAtA[iNdEx:])
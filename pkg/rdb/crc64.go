@@ -0,0 +1,14 @@
+package rdb
+
+import "hash/crc64"
+
+// crcTable is the table of the Jones CRC64 used by Redis.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// Checksum updates crc with p, using the Redis CRC64 of RDB files
+// and DUMP payloads. The checksum of a whole input starts from 0.
+func Checksum(crc uint64, p []byte) uint64 {
+	// Redis doesn't invert the CRC before and after updating it,
+	// unlike the hash/crc64 package.
+	return ^crc64.Update(^crc, crcTable, p)
+}
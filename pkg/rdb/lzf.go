@@ -0,0 +1,52 @@
+package rdb
+
+import "fmt"
+
+// lzfDecompress decompresses LZF compressed strings into length bytes.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// Literal run of ctrl+1 bytes.
+		if ctrl < 1<<5 {
+			ctrl++
+			if i+ctrl > len(in) {
+				return nil, fmt.Errorf("rdb: invalid lzf literal")
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+
+		// Back reference of n bytes at offset ref.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("rdb: invalid lzf reference")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("rdb: invalid lzf reference")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("rdb: invalid lzf reference")
+		}
+		// References may overlap the bytes they append.
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return nil, fmt.Errorf("rdb: lzf length %d, expected %d", len(out), length)
+	}
+
+	return out, nil
+}
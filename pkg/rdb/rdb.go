@@ -0,0 +1,74 @@
+// Package rdb understands the Redis RDB serialization format,
+// also used by DUMP payloads.
+package rdb
+
+// RDB object types, the first byte of a DUMP payload.
+const (
+	TypeString           = 0
+	TypeList             = 1
+	TypeSet              = 2
+	TypeZSet             = 3
+	TypeHash             = 4
+	TypeZSet2            = 5
+	TypeModule           = 6
+	TypeModule2          = 7
+	TypeHashZipmap       = 9
+	TypeListZiplist      = 10
+	TypeSetIntset        = 11
+	TypeZSetZiplist      = 12
+	TypeHashZiplist      = 13
+	TypeListQuicklist    = 14
+	TypeStreamListpacks  = 15
+	TypeHashListpack     = 16
+	TypeZSetListpack     = 17
+	TypeListQuicklist2   = 18
+	TypeStreamListpacks2 = 19
+	TypeSetListpack      = 20
+	TypeStreamListpacks3 = 21
+)
+
+// TypeName returns the Redis type name of an RDB object type,
+// as returned by the TYPE command. Unknown types return "".
+func TypeName(t byte) string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList, TypeListZiplist, TypeListQuicklist, TypeListQuicklist2:
+		return "list"
+	case TypeSet, TypeSetIntset, TypeSetListpack:
+		return "set"
+	case TypeZSet, TypeZSet2, TypeZSetZiplist, TypeZSetListpack:
+		return "zset"
+	case TypeHash, TypeHashZipmap, TypeHashZiplist, TypeHashListpack:
+		return "hash"
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return "stream"
+	}
+	return ""
+}
+
+// DumpType returns the Redis type name of a DUMP payload.
+func DumpType(dump string) string {
+	if len(dump) == 0 {
+		return ""
+	}
+	return TypeName(dump[0])
+}
+
+// DumpBody returns a DUMP payload without its trailer, the RDB version
+// and the CRC64 checksum, to compare values dumped by different versions.
+func DumpBody(dump string) string {
+	if len(dump) < 10 {
+		return dump
+	}
+	return dump[:len(dump)-10]
+}
+
+// DumpVersion returns the RDB version of a DUMP payload, 0 if invalid.
+func DumpVersion(dump string) int {
+	if len(dump) < 11 {
+		return 0
+	}
+	n := len(dump) - 10
+	return int(dump[n]) | int(dump[n+1])<<8
+}
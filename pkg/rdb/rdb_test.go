@@ -0,0 +1,261 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	if crc := Checksum(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("unexpected checksum %x", crc)
+	}
+}
+
+func TestDump(t *testing.T) {
+	// DUMP of the integer 10, from the Redis documentation.
+	expected := "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+	if d := Dump(TypeString, []byte{0xc0, 0x0a}, 9); d != expected {
+		t.Errorf("expected %q, got %q", expected, d)
+	}
+	if DumpType(expected) != "string" || DumpBody(expected) != "\x00\xc0\n" {
+		t.Error("unexpected dump type or body")
+	}
+}
+
+func TestLZF(t *testing.T) {
+	// Literal "abc", then a back reference of 6 bytes at offset 3.
+	in := []byte{0x02, 'a', 'b', 'c', 0x80, 0x02}
+	out, err := lzfDecompress(in, 9)
+	if err != nil || string(out) != "abcabcabc" {
+		t.Errorf("unexpected %q, %v", out, err)
+	}
+}
+
+// file builds an RDB file with a valid checksum.
+func file(body ...string) []byte {
+	b := []byte("REDIS0009")
+	for _, s := range body {
+		b = append(b, s...)
+	}
+	b = append(b, opEOF)
+
+	var crc [8]byte
+	binary.LittleEndian.PutUint64(crc[:], Checksum(0, b))
+	return append(b, crc[:]...)
+}
+
+func TestReader(t *testing.T) {
+	var expire [8]byte
+	binary.LittleEndian.PutUint64(expire[:], 1700000000000)
+
+	rdb := file(
+		"\xfa\x09redis-ver\x056.2.0",
+		"\xfe\x00\xfb\x03\x01",
+		"\xfc"+string(expire[:])+"\x00\x04key1\x06value1",
+		"\x00\x04key2\xc0\x0a",
+		"\x01\x04list\x02\x01a\x01b",
+		"\xfe\x01",
+		"\x00\xc0\x03\x01v",
+	)
+
+	r, err := NewReader(bytes.NewReader(rdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result []Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, *e)
+	}
+
+	expected := []Entry{
+		{DB: 0, Key: "key1", Dump: Dump(TypeString, []byte("\x06value1"), 9), ExpireAt: 1700000000000},
+		{DB: 0, Key: "key2", Dump: Dump(TypeString, []byte{0xc0, 0x0a}, 9)},
+		{DB: 0, Key: "list", Dump: Dump(TypeList, []byte("\x02\x01a\x01b"), 9)},
+		{DB: 1, Key: "3", Dump: Dump(TypeString, []byte("\x01v"), 9)},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %q, result: %q", expected, result)
+	}
+
+	// Corrupt the last value.
+	rdb[len(rdb)-10] = 'w'
+	r, _ = NewReader(bytes.NewReader(rdb))
+	for err == nil {
+		_, err = r.Next()
+	}
+	if err == io.EOF {
+		t.Error("expected a checksum error")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteAux("redis-ver", "6.2.0")
+
+	long := string(bytes.Repeat([]byte("x"), 20000))
+	expected := []Entry{
+		{DB: 0, Key: "key1", Dump: Dump(TypeString, []byte("\x06value1"), 9)},
+		{DB: 0, Key: "key2", Dump: Dump(TypeList, []byte("\x02\x01a\x01b"), 9), ExpireAt: 1700000000000},
+		{DB: 2, Key: long, Dump: Dump(TypeString, []byte{0xc0, 0x0a}, 9)},
+	}
+	db := -1
+	for _, e := range expected {
+		if e.DB != db {
+			w.SelectDB(e.DB)
+			db = e.DB
+		}
+		if err := w.Write(e.Key, e.Dump, e.ExpireAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, *e)
+	}
+
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %q, result: %q", expected, result)
+	}
+	if v := DumpVersion(expected[0].Dump); v != 9 {
+		t.Errorf("expected dump version 9, got %d", v)
+	}
+}
+
+// lp encodes elements as a listpack, small integers as such.
+func lp(elements ...string) string {
+	var b []byte
+	for _, e := range elements {
+		if n, err := strconv.Atoi(e); err == nil && n >= 0 && n < 128 {
+			b = append(b, byte(n), 1)
+			continue
+		}
+		b = append(b, 0x80|byte(len(e)))
+		b = append(b, e...)
+		b = append(b, byte(1+len(e)))
+	}
+	b = append(b, 0xff)
+
+	header := make([]byte, 6)
+	binary.LittleEndian.PutUint32(header, uint32(6+len(b)))
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(elements)))
+	s := string(append(header, b...))
+	return string(rune(len(s))) + s
+}
+
+func TestDecode(t *testing.T) {
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, math.Float64bits(1.5))
+
+	tests := []struct {
+		dump     string
+		expected *Value
+	}{
+		{Dump(TypeString, []byte("\x06value1"), 9), &Value{Type: "string", String: "value1"}},
+		{Dump(TypeList, []byte("\x02\x01a\x01b"), 9), &Value{Type: "list", Elements: []string{"a", "b"}}},
+		{Dump(TypeListQuicklist2, []byte("\x01\x02"+lp("a", "1")), 10), &Value{Type: "list", Elements: []string{"a", "1"}}},
+		{Dump(TypeHash, []byte("\x01\x01f\x01v"), 9), &Value{Type: "hash", Hash: map[string]string{"f": "v"}}},
+		{Dump(TypeSetIntset, []byte("\x0c\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\xfe\xff"), 9), &Value{Type: "set", Elements: []string{"1", "-2"}}},
+		{Dump(TypeHashZiplist, []byte("\x10"+"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"+"\x00\x01f"+"\x03\xf2"+"\xff"), 9), &Value{Type: "hash", Hash: map[string]string{"f": "1"}}},
+		{Dump(TypeHashListpack, []byte(lp("f", "v")), 10), &Value{Type: "hash", Hash: map[string]string{"f": "v"}}},
+		{Dump(TypeZSet2, append([]byte("\x01\x01m"), score...), 9), &Value{Type: "zset", ZSet: []Member{{"m", 1.5}}}},
+		{Dump(TypeZSetListpack, []byte(lp("m", "2")), 10), &Value{Type: "zset", ZSet: []Member{{"m", 2}}}},
+		{
+			// Master ID 5-0 and field f, then an entry 5-1 with the master fields.
+			Dump(TypeStreamListpacks, []byte("\x01\x10\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00\x00"+
+				lp("1", "0", "1", "f", "0", "2", "0", "1", "v", "4")+"\x01\x05\x01\x00"), 9),
+			&Value{Type: "stream", Stream: []StreamEntry{{ID: "5-1", Fields: []string{"f", "v"}}}},
+		},
+	}
+
+	for _, test := range tests {
+		v, err := Decode(test.dump)
+		if err != nil {
+			t.Errorf("%q: %s", test.dump, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected: %v, result: %v", test.expected, v)
+		}
+	}
+
+	if _, err := Decode(Dump(TypeModule2, []byte("\x00"), 9)); err != ErrUnsupported {
+		t.Errorf("modules should be unsupported, got %v", err)
+	}
+}
+
+func TestValueDump(t *testing.T) {
+	values := []*Value{
+		{Type: "string", String: "v"},
+		{Type: "list", Elements: []string{"a", "b", "a"}},
+		{Type: "set", Elements: []string{"a", "b"}},
+		{Type: "hash", Hash: map[string]string{"f": "v", "g": "w"}},
+		{Type: "zset", ZSet: []Member{{"a", 1}, {"b", -2.5}}},
+	}
+	for _, v := range values {
+		dump, err := v.Dump(9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if DumpVersion(dump) != 9 {
+			t.Errorf("%s: wrong version %d", v.Type, DumpVersion(dump))
+		}
+		if decoded, err := Decode(dump); err != nil || !reflect.DeepEqual(decoded, v) {
+			t.Errorf("%s: expected %+v, decoded %+v, error %v", v.Type, v, decoded, err)
+		}
+	}
+
+	dump, _ := (&Value{Type: "set", Elements: []string{"a", "a"}}).Dump(9)
+	if v, _ := Decode(dump); len(v.Elements) != 1 {
+		t.Errorf("duplicated set elements: %q", v.Elements)
+	}
+
+	if _, err := (&Value{Type: "stream"}).Dump(9); err != ErrUnsupported {
+		t.Errorf("streams should be unsupported, got %v", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	v := &Value{Type: "zset", ZSet: []Member{{"a", 1}, {"b", 2.5}}}
+	expected := [][]string{{"DEL", "z"}, {"ZADD", "z", "1", "a", "2.5", "b"}}
+	if cmds := v.Commands("z"); !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("expected: %q, result: %q", expected, cmds)
+	}
+
+	elements := make([]string, commandElements+1)
+	v = &Value{Type: "list", Elements: elements}
+	if cmds := v.Commands("l"); len(cmds) != 3 || len(cmds[2]) != 3 {
+		t.Errorf("unexpected chunks: %d", len(cmds))
+	}
+}
@@ -0,0 +1,536 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Opcodes of the RDB file format, preceding keys or metadata.
+const (
+	opSlotInfo      = 0xf4
+	opFunctionPreGA = 0xf5
+	opFunction2     = 0xf6
+	opModuleAux     = 0xf7
+	opIdle          = 0xf8
+	opFreq          = 0xf9
+	opAux           = 0xfa
+	opResizeDB      = 0xfb
+	opExpireTimeMs  = 0xfc
+	opExpireTime    = 0xfd
+	opSelectDB      = 0xfe
+	opEOF           = 0xff
+)
+
+// Opcodes of module values.
+const (
+	moduleEOF = iota
+	moduleSInt
+	moduleUInt
+	moduleFloat
+	moduleDouble
+	moduleString
+)
+
+// maxLength bounds lengths read from RDB files, to fail on corrupted files
+// instead of allocating unbounded memory.
+const maxLength = 1 << 32
+
+// Entry is a key read from an RDB file.
+// Dump is the key value as a DUMP payload, of the RDB file version.
+// ExpireAt is the expire time in Unix milliseconds, 0 for no expiration.
+type Entry struct {
+	DB       int
+	Key      string
+	Dump     string
+	ExpireAt int64
+}
+
+// Reader reads the keys of an RDB file.
+type Reader struct {
+	r       *bufio.Reader
+	version int
+	db      int
+	crc     uint64
+	done    bool
+	// object captures the bytes of the object being read, if not nil.
+	object *bytes.Buffer
+}
+
+// NewReader reads the RDB header from r.
+// When r is a *bufio.Reader nothing is read past the RDB checksum,
+// so that RDB payloads followed by other data can be read.
+func NewReader(r io.Reader) (*Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	rdr := &Reader{r: br}
+
+	header := make([]byte, 9)
+	if err := rdr.read(header); err != nil {
+		return nil, err
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("rdb: invalid header %q", header)
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return nil, fmt.Errorf("rdb: invalid version %q", header[5:])
+	}
+	rdr.version = version
+
+	return rdr, nil
+}
+
+// Version returns the RDB version of the file.
+func (r *Reader) Version() int {
+	return r.version
+}
+
+// Next returns the next key of the file.
+// It returns io.EOF after the last key, once the file checksum verified.
+func (r *Reader) Next() (*Entry, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	var expireAt int64
+	for {
+		op, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opEOF:
+			r.done = true
+			return nil, r.checksum()
+		case opSelectDB:
+			db, err := r.readLen()
+			if err != nil {
+				return nil, err
+			}
+			r.db = int(db)
+		case opResizeDB:
+			err = r.skipLens(2)
+		case opSlotInfo:
+			err = r.skipLens(3)
+		case opAux:
+			if _, err = r.readString(); err == nil {
+				_, err = r.readString()
+			}
+		case opFunction2:
+			_, err = r.readString()
+		case opModuleAux:
+			// Module id, when opcode and when, then the module value.
+			if err = r.skipLens(3); err == nil {
+				err = r.skipModuleValue()
+			}
+		case opFunctionPreGA:
+			return nil, fmt.Errorf("rdb: unsupported pre-GA functions")
+		case opExpireTime:
+			var b [4]byte
+			if err = r.read(b[:]); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint32(b[:])) * 1000
+			}
+		case opExpireTimeMs:
+			var b [8]byte
+			if err = r.read(b[:]); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint64(b[:]))
+			}
+		case opFreq:
+			_, err = r.readByte()
+		case opIdle:
+			_, err = r.readLen()
+		default:
+			return r.readEntry(op, expireAt)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readEntry reads a key and its object of type t.
+func (r *Reader) readEntry(t byte, expireAt int64) (*Entry, error) {
+	key, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	r.object = &bytes.Buffer{}
+	err = r.skipObject(t)
+	object := r.object.Bytes()
+	r.object = nil
+	if err != nil {
+		return nil, fmt.Errorf("rdb: key %s: %s", key, err)
+	}
+
+	return &Entry{
+		DB:       r.db,
+		Key:      key,
+		Dump:     Dump(t, object, r.version),
+		ExpireAt: expireAt,
+	}, nil
+}
+
+// checksum verifies the checksum trailing the file, 0 when disabled.
+func (r *Reader) checksum() error {
+	if r.version < 5 {
+		return io.EOF
+	}
+
+	crc := r.crc
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if sum := binary.LittleEndian.Uint64(b[:]); sum != 0 && sum != crc {
+		return fmt.Errorf("rdb: checksum mismatch")
+	}
+
+	return io.EOF
+}
+
+// read reads exactly len(p) bytes, updating the checksum.
+func (r *Reader) read(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	r.crc = Checksum(r.crc, p)
+	if r.object != nil {
+		r.object.Write(p)
+	}
+
+	return nil
+}
+
+func (r *Reader) readByte() (byte, error) {
+	var b [1]byte
+	err := r.read(b[:])
+	return b[0], err
+}
+
+// readLength reads a length, or the encoding of a special string
+// when encoded is true.
+func (r *Reader) readLength() (n uint64, encoded bool, err error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := r.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 2:
+		var p [8]byte
+		switch b {
+		case 0x80:
+			err = r.read(p[:4])
+			return uint64(binary.BigEndian.Uint32(p[:4])), false, err
+		case 0x81:
+			err = r.read(p[:])
+			return binary.BigEndian.Uint64(p[:]), false, err
+		}
+		return 0, false, fmt.Errorf("rdb: invalid length 0x%x", b)
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+// readLen reads a length, not a special string.
+func (r *Reader) readLen() (uint64, error) {
+	n, encoded, err := r.readLength()
+	if err == nil && encoded {
+		err = fmt.Errorf("rdb: unexpected encoded length")
+	}
+	return n, err
+}
+
+func (r *Reader) skipLens(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := r.readLen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBytes reads n bytes.
+func (r *Reader) readBytes(n uint64) ([]byte, error) {
+	if n > maxLength {
+		return nil, fmt.Errorf("rdb: invalid length %d", n)
+	}
+	p := make([]byte, n)
+	return p, r.read(p)
+}
+
+// readString reads a string, decoding integers and LZF compressed strings.
+func (r *Reader) readString() (string, error) {
+	n, encoded, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		p, err := r.readBytes(n)
+		return string(p), err
+	}
+
+	var p [4]byte
+	switch n {
+	case 0:
+		err = r.read(p[:1])
+		return strconv.Itoa(int(int8(p[0]))), err
+	case 1:
+		err = r.read(p[:2])
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p[:2])))), err
+	case 2:
+		err = r.read(p[:4])
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p[:4])))), err
+	case 3:
+		clen, err := r.readLen()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := r.readLen()
+		if err != nil {
+			return "", err
+		}
+		if ulen > maxLength {
+			return "", fmt.Errorf("rdb: invalid length %d", ulen)
+		}
+		c, err := r.readBytes(clen)
+		if err != nil {
+			return "", err
+		}
+		u, err := lzfDecompress(c, int(ulen))
+		return string(u), err
+	}
+
+	return "", fmt.Errorf("rdb: invalid string encoding %d", n)
+}
+
+// skipStrings reads n strings.
+func (r *Reader) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipObject reads an object of type t, captured by the caller.
+func (r *Reader) skipObject(t byte) error {
+	switch t {
+	case TypeString, TypeHashZipmap, TypeListZiplist, TypeSetIntset, TypeZSetZiplist,
+		TypeHashZiplist, TypeHashListpack, TypeZSetListpack, TypeSetListpack:
+		_, err := r.readString()
+		return err
+	case TypeList, TypeSet, TypeListQuicklist:
+		n, err := r.readLen()
+		if err != nil {
+			return err
+		}
+		return r.skipStrings(n)
+	case TypeHash:
+		n, err := r.readLen()
+		if err != nil {
+			return err
+		}
+		return r.skipStrings(2 * n)
+	case TypeZSet, TypeZSet2:
+		n, err := r.readLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.readString(); err != nil {
+				return err
+			}
+			if err := r.skipScore(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeListQuicklist2:
+		n, err := r.readLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// Container type, then the node.
+			if _, err := r.readLen(); err != nil {
+				return err
+			}
+			if _, err := r.readString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return r.skipStream(t)
+	case TypeModule2:
+		if _, err := r.readLen(); err != nil {
+			return err
+		}
+		return r.skipModuleValue()
+	}
+
+	return fmt.Errorf("unsupported object type %d", t)
+}
+
+// skipScore reads a sorted set score, a binary double with TypeZSet2.
+func (r *Reader) skipScore(t byte) error {
+	if t == TypeZSet2 {
+		_, err := r.readBytes(8)
+		return err
+	}
+
+	n, err := r.readByte()
+	if err != nil {
+		return err
+	}
+	// 253, 254 and 255 are NaN, +inf and -inf.
+	if n >= 253 {
+		return nil
+	}
+	_, err = r.readBytes(uint64(n))
+	return err
+}
+
+// skipStream reads a stream, its consumer groups and their pending entries.
+func (r *Reader) skipStream(t byte) error {
+	// Listpacks of entries, keyed by their master ID.
+	n, err := r.readLen()
+	if err != nil {
+		return err
+	}
+	if err := r.skipStrings(2 * n); err != nil {
+		return err
+	}
+
+	// Length and last ID, then first ID, max deleted ID and entries added.
+	lens := 3
+	if t != TypeStreamListpacks {
+		lens += 5
+	}
+	if err := r.skipLens(lens); err != nil {
+		return err
+	}
+
+	groups, err := r.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if _, err := r.readString(); err != nil {
+			return err
+		}
+		// Last delivered ID, then entries read.
+		lens := 2
+		if t != TypeStreamListpacks {
+			lens++
+		}
+		if err := r.skipLens(lens); err != nil {
+			return err
+		}
+
+		// Pending entries: raw ID, delivery time and delivery count.
+		pending, err := r.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			if _, err := r.readBytes(16 + 8); err != nil {
+				return err
+			}
+			if _, err := r.readLen(); err != nil {
+				return err
+			}
+		}
+
+		consumers, err := r.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if _, err := r.readString(); err != nil {
+				return err
+			}
+			// Seen time, then active time.
+			times := uint64(8)
+			if t == TypeStreamListpacks3 {
+				times += 8
+			}
+			if _, err := r.readBytes(times); err != nil {
+				return err
+			}
+
+			// Raw IDs of the consumer pending entries.
+			pending, err := r.readLen()
+			if err != nil {
+				return err
+			}
+			if _, err := r.readBytes(16 * pending); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// skipModuleValue reads the opcodes of a module value until its EOF.
+func (r *Reader) skipModuleValue() error {
+	for {
+		op, err := r.readLen()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case moduleEOF:
+			return nil
+		case moduleSInt, moduleUInt:
+			_, err = r.readLen()
+		case moduleFloat:
+			_, err = r.readBytes(4)
+		case moduleDouble:
+			_, err = r.readBytes(8)
+		case moduleString:
+			_, err = r.readString()
+		default:
+			err = fmt.Errorf("rdb: invalid module opcode %d", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Dump encodes an object of type t as a DUMP payload:
+// the type, the object, the RDB version and a CRC64 of all of them.
+func Dump(t byte, object []byte, version int) string {
+	b := make([]byte, 0, 1+len(object)+10)
+	b = append(b, t)
+	b = append(b, object...)
+	b = append(b, byte(version), byte(version>>8))
+
+	var crc [8]byte
+	binary.LittleEndian.PutUint64(crc[:], Checksum(0, b))
+
+	return string(append(b, crc[:]...))
+}
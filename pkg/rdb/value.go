@@ -0,0 +1,734 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ErrUnsupported is returned decoding values without a logical form,
+// like modules or streams with consumer groups.
+var ErrUnsupported = errors.New("rdb: unsupported value")
+
+// Value is the logical value of a key, independent of its encoding.
+// Type is the Redis type name, and the field of the type is set:
+// String for strings, Elements for lists and sets, Hash for hashes,
+// ZSet for sorted sets and Stream for streams.
+type Value struct {
+	Type     string
+	String   string
+	Elements []string
+	Hash     map[string]string
+	ZSet     []Member
+	Stream   []StreamEntry
+}
+
+// Member is a sorted set member.
+type Member struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// StreamEntry is a stream entry, with its field and value pairs.
+type StreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// Decode returns the logical value of a DUMP payload.
+func Decode(dump string) (*Value, error) {
+	if len(dump) < 11 {
+		return nil, fmt.Errorf("rdb: invalid dump")
+	}
+	body := DumpBody(dump)
+	r := &Reader{
+		r:       bufio.NewReader(bytes.NewReader([]byte(body[1:]))),
+		version: DumpVersion(dump),
+	}
+
+	t := body[0]
+	v := &Value{Type: TypeName(t)}
+	var err error
+	switch t {
+	case TypeString:
+		v.String, err = r.readString()
+	case TypeList, TypeSet:
+		v.Elements, err = r.readStrings()
+	case TypeHash:
+		v.Hash, err = r.readHash()
+	case TypeZSet, TypeZSet2:
+		v.ZSet, err = r.readZSet(t)
+	case TypeHashZipmap:
+		var zm string
+		if zm, err = r.readString(); err == nil {
+			v.Hash, err = zipmap([]byte(zm))
+		}
+	case TypeListZiplist, TypeZSetZiplist, TypeHashZiplist:
+		var zl string
+		if zl, err = r.readString(); err == nil {
+			v.Elements, err = ziplist([]byte(zl))
+		}
+	case TypeHashListpack, TypeZSetListpack, TypeSetListpack:
+		var lp string
+		if lp, err = r.readString(); err == nil {
+			v.Elements, err = listpack([]byte(lp))
+		}
+	case TypeSetIntset:
+		var is string
+		if is, err = r.readString(); err == nil {
+			v.Elements, err = intset([]byte(is))
+		}
+	case TypeListQuicklist, TypeListQuicklist2:
+		v.Elements, err = r.readQuicklist(t)
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		v.Stream, err = r.readStream(t)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Ziplists and listpacks of hashes and sorted sets hold pairs.
+	switch t {
+	case TypeHashZiplist, TypeHashListpack:
+		v.Hash, err = pairs(v.Elements)
+		v.Elements = nil
+	case TypeZSetZiplist, TypeZSetListpack:
+		v.ZSet, err = members(v.Elements)
+		v.Elements = nil
+	}
+
+	return v, err
+}
+
+// readStrings reads a length, then as many strings.
+func (r *Reader) readStrings() ([]string, error) {
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLength {
+		return nil, fmt.Errorf("rdb: invalid length %d", n)
+	}
+
+	s := make([]string, n)
+	for i := range s {
+		if s[i], err = r.readString(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// readHash reads a number of fields, then their field and value pairs.
+func (r *Reader) readHash() (map[string]string, error) {
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLength {
+		return nil, fmt.Errorf("rdb: invalid length %d", n)
+	}
+
+	m := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		field, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if m[field], err = r.readString(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// readZSet reads the members of a sorted set of type t.
+func (r *Reader) readZSet(t byte) ([]Member, error) {
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLength {
+		return nil, fmt.Errorf("rdb: invalid length %d", n)
+	}
+
+	z := make([]Member, n)
+	for i := range z {
+		if z[i].Member, err = r.readString(); err != nil {
+			return nil, err
+		}
+		if z[i].Score, err = r.readScore(t); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// readScore reads a sorted set score, a binary double with TypeZSet2.
+func (r *Reader) readScore(t byte) (float64, error) {
+	if t == TypeZSet2 {
+		b, err := r.readBytes(8)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	}
+
+	n, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := r.readBytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// readQuicklist reads the ziplist, or listpack and plain, nodes of a list.
+func (r *Reader) readQuicklist(t byte) ([]string, error) {
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []string
+	for i := uint64(0); i < n; i++ {
+		container := uint64(2)
+		if t == TypeListQuicklist2 {
+			if container, err = r.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		node, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var s []string
+		switch {
+		case t == TypeListQuicklist:
+			s, err = ziplist([]byte(node))
+		case container == 1:
+			s = []string{node}
+		default:
+			s, err = listpack([]byte(node))
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, s...)
+	}
+	return elements, nil
+}
+
+// readStream reads the entries of a stream of type t.
+// Streams with consumer groups are unsupported.
+func (r *Reader) readStream(t byte) ([]StreamEntry, error) {
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StreamEntry
+	for i := uint64(0); i < n; i++ {
+		master, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		lp, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(master) != 16 {
+			return nil, fmt.Errorf("rdb: invalid stream id")
+		}
+		elements, err := listpack([]byte(lp))
+		if err != nil {
+			return nil, err
+		}
+		e, err := streamEntries(binary.BigEndian.Uint64([]byte(master[:8])), binary.BigEndian.Uint64([]byte(master[8:])), elements)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+
+	// Length and last ID, then first ID, max deleted ID and entries added.
+	lens := 3
+	if t != TypeStreamListpacks {
+		lens += 5
+	}
+	if err := r.skipLens(lens); err != nil {
+		return nil, err
+	}
+	groups, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if groups > 0 || len(entries) == 0 {
+		return nil, ErrUnsupported
+	}
+
+	return entries, nil
+}
+
+// streamEntries decodes the elements of a stream listpack: a master entry
+// with the count of valid and deleted entries and the master fields,
+// then entries with their flags, ID relative to the master ID, fields
+// unless the same as the master fields, values, and their element count.
+func streamEntries(ms, seq uint64, elements []string) ([]StreamEntry, error) {
+	const (
+		flagDeleted    = 1
+		flagSameFields = 2
+	)
+
+	e := &elementReader{elements: elements}
+	count, deleted := e.int(), e.int()
+	master := make([]string, e.count())
+	for i := range master {
+		master[i] = e.next()
+	}
+	e.next()
+
+	var entries []StreamEntry
+	for i := int64(0); i < count+deleted && e.err == nil; i++ {
+		flags := e.int()
+		id := fmt.Sprintf("%d-%d", ms+uint64(e.int()), seq+uint64(e.int()))
+
+		fields := master
+		if flags&flagSameFields == 0 {
+			fields = make([]string, e.count())
+			for j := range fields {
+				fields[j] = e.next()
+			}
+		}
+		entry := StreamEntry{ID: id}
+		for _, field := range fields {
+			entry.Fields = append(entry.Fields, field, e.next())
+		}
+		e.next()
+
+		if flags&flagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, e.err
+}
+
+// elementReader reads the elements of a listpack, recording
+// the first error.
+type elementReader struct {
+	elements []string
+	err      error
+}
+
+func (e *elementReader) next() string {
+	if len(e.elements) == 0 {
+		e.err = fmt.Errorf("rdb: truncated stream")
+		return ""
+	}
+	s := e.elements[0]
+	e.elements = e.elements[1:]
+	return s
+}
+
+func (e *elementReader) int() int64 {
+	n, err := strconv.ParseInt(e.next(), 10, 64)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("rdb: invalid stream integer")
+	}
+	return n
+}
+
+// count reads a number of elements, at most the remaining elements.
+func (e *elementReader) count() int {
+	n := e.int()
+	if n < 0 || n > int64(len(e.elements)) {
+		if e.err == nil {
+			e.err = fmt.Errorf("rdb: invalid stream count")
+		}
+		return 0
+	}
+	return int(n)
+}
+
+// pairs returns the field and value pairs of s as a map.
+func pairs(s []string) (map[string]string, error) {
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("rdb: odd number of hash elements")
+	}
+	m := make(map[string]string, len(s)/2)
+	for i := 0; i < len(s); i += 2 {
+		m[s[i]] = s[i+1]
+	}
+	return m, nil
+}
+
+// members returns the member and score pairs of s.
+func members(s []string) ([]Member, error) {
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("rdb: odd number of sorted set elements")
+	}
+	z := make([]Member, len(s)/2)
+	for i := range z {
+		score, err := strconv.ParseFloat(s[2*i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("rdb: invalid score %q", s[2*i+1])
+		}
+		z[i] = Member{Member: s[2*i], Score: score}
+	}
+	return z, nil
+}
+
+// ziplist returns the elements of a ziplist.
+func ziplist(b []byte) ([]string, error) {
+	if len(b) < 11 {
+		return nil, fmt.Errorf("rdb: invalid ziplist")
+	}
+
+	var s []string
+	for i := 10; ; {
+		if i >= len(b) {
+			return nil, fmt.Errorf("rdb: truncated ziplist")
+		}
+		if b[i] == 0xff {
+			return s, nil
+		}
+
+		// Previous entry length.
+		if b[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, fmt.Errorf("rdb: truncated ziplist")
+		}
+
+		enc := b[i]
+		var n, size int
+		switch {
+		case enc>>6 == 0:
+			n, size = int(enc&0x3f), 1
+		case enc>>6 == 1 && i+1 < len(b):
+			n, size = int(enc&0x3f)<<8|int(b[i+1]), 2
+		case enc == 0x80 && i+4 < len(b):
+			n, size = int(binary.BigEndian.Uint32(b[i+1:])), 5
+		case enc>>6 == 2:
+			return nil, fmt.Errorf("rdb: invalid ziplist encoding 0x%x", enc)
+		default:
+			v, n, err := ziplistInt(b[i+1:], enc)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, strconv.FormatInt(v, 10))
+			i += 1 + n
+			continue
+		}
+
+		i += size
+		if n < 0 || i+n > len(b) {
+			return nil, fmt.Errorf("rdb: truncated ziplist")
+		}
+		s = append(s, string(b[i:i+n]))
+		i += n
+	}
+}
+
+// ziplistInt decodes the integer of encoding enc at the start of b,
+// returning it with its size.
+func ziplistInt(b []byte, enc byte) (int64, int, error) {
+	size := map[byte]int{0xc0: 2, 0xd0: 4, 0xe0: 8, 0xf0: 3, 0xfe: 1}[enc]
+	if enc >= 0xf1 && enc <= 0xfd {
+		return int64(enc&0x0f) - 1, 0, nil
+	}
+	if size == 0 {
+		return 0, 0, fmt.Errorf("rdb: invalid ziplist encoding 0x%x", enc)
+	}
+	if len(b) < size {
+		return 0, 0, fmt.Errorf("rdb: truncated ziplist")
+	}
+	return littleEndian(b[:size]), size, nil
+}
+
+// listpack returns the elements of a listpack.
+func listpack(b []byte) ([]string, error) {
+	if len(b) < 7 {
+		return nil, fmt.Errorf("rdb: invalid listpack")
+	}
+
+	var s []string
+	for i := 6; ; {
+		if i >= len(b) {
+			return nil, fmt.Errorf("rdb: truncated listpack")
+		}
+		enc := b[i]
+		if enc == 0xff {
+			return s, nil
+		}
+
+		// Encoding and value sizes, strings being appended as is.
+		var head, n int
+		str := false
+		switch {
+		case enc>>7 == 0:
+			s = append(s, strconv.Itoa(int(enc)))
+			head = 1
+		case enc>>6 == 2:
+			head, n, str = 1, int(enc&0x3f), true
+		case enc>>5 == 6 && i+1 < len(b):
+			v := int64(enc&0x1f)<<8 | int64(b[i+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			s = append(s, strconv.FormatInt(v, 10))
+			head = 2
+		case enc>>4 == 0xe && i+1 < len(b):
+			head, n, str = 2, int(enc&0x0f)<<8|int(b[i+1]), true
+		case enc == 0xf0 && i+4 < len(b):
+			head, n, str = 5, int(binary.LittleEndian.Uint32(b[i+1:])), true
+		case enc >= 0xf1 && enc <= 0xf4:
+			n = map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[enc]
+			if i+1+n > len(b) {
+				return nil, fmt.Errorf("rdb: truncated listpack")
+			}
+			s = append(s, strconv.FormatInt(littleEndian(b[i+1:i+1+n]), 10))
+			head = 1
+		default:
+			return nil, fmt.Errorf("rdb: invalid listpack encoding 0x%x", enc)
+		}
+
+		if n < 0 || i+head+n > len(b) {
+			return nil, fmt.Errorf("rdb: truncated listpack")
+		}
+		if str {
+			s = append(s, string(b[i+head:i+head+n]))
+		}
+		i += head + n + backlenSize(head+n)
+	}
+}
+
+// backlenSize returns the size of the backward length of a listpack
+// entry of size n.
+func backlenSize(n int) int {
+	switch {
+	case n < 1<<7:
+		return 1
+	case n < 1<<14:
+		return 2
+	case n < 1<<21:
+		return 3
+	case n < 1<<28:
+		return 4
+	}
+	return 5
+}
+
+// littleEndian decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndian(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(u<<shift) >> shift
+}
+
+// intset returns the integers of an intset.
+func intset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("rdb: invalid intset")
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (size != 2 && size != 4 && size != 8) || len(b) != 8+size*n {
+		return nil, fmt.Errorf("rdb: invalid intset")
+	}
+
+	s := make([]string, n)
+	for i := range s {
+		s[i] = strconv.FormatInt(littleEndian(b[8+i*size:8+(i+1)*size]), 10)
+	}
+	return s, nil
+}
+
+// zipmap returns the fields of a zipmap.
+func zipmap(b []byte) (map[string]string, error) {
+	m := map[string]string{}
+	i := 1
+	length := func() (int, error) {
+		if i >= len(b) {
+			return 0, fmt.Errorf("rdb: truncated zipmap")
+		}
+		if b[i] < 254 {
+			i++
+			return int(b[i-1]), nil
+		}
+		if b[i] == 255 || i+5 > len(b) {
+			return 0, fmt.Errorf("rdb: invalid zipmap")
+		}
+		i += 5
+		return int(binary.LittleEndian.Uint32(b[i-4:])), nil
+	}
+
+	for i < len(b) && b[i] != 0xff {
+		n, err := length()
+		if err != nil {
+			return nil, err
+		}
+		if i+n > len(b) {
+			return nil, fmt.Errorf("rdb: truncated zipmap")
+		}
+		field := string(b[i : i+n])
+		i += n
+
+		if n, err = length(); err != nil {
+			return nil, err
+		}
+		// Free bytes follow the value.
+		if i+1+n > len(b) {
+			return nil, fmt.Errorf("rdb: truncated zipmap")
+		}
+		free := int(b[i])
+		i++
+		m[field] = string(b[i : i+n])
+		i += n + free
+	}
+	if i >= len(b) {
+		return nil, fmt.Errorf("rdb: truncated zipmap")
+	}
+
+	return m, nil
+}
+
+// Dump encodes the value as a DUMP payload of version, with the plain
+// encodings of strings, lists, sets, hashes and sorted sets, loaded by
+// every Redis version. Duplicated set elements are dropped, hash fields
+// sorted. It returns ErrUnsupported for streams.
+func (v *Value) Dump(version int) (string, error) {
+	var b bytes.Buffer
+	w := &Writer{w: &b}
+
+	var t byte
+	switch v.Type {
+	case "string":
+		t = TypeString
+		w.writeString(v.String)
+	case "list":
+		t = TypeList
+		w.writeLength(uint64(len(v.Elements)))
+		for _, e := range v.Elements {
+			w.writeString(e)
+		}
+	case "set":
+		t = TypeSet
+		seen := make(map[string]bool, len(v.Elements))
+		var elements []string
+		for _, e := range v.Elements {
+			if !seen[e] {
+				seen[e] = true
+				elements = append(elements, e)
+			}
+		}
+		w.writeLength(uint64(len(elements)))
+		for _, e := range elements {
+			w.writeString(e)
+		}
+	case "hash":
+		t = TypeHash
+		fields := make([]string, 0, len(v.Hash))
+		for field := range v.Hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		w.writeLength(uint64(len(fields)))
+		for _, field := range fields {
+			w.writeString(field)
+			w.writeString(v.Hash[field])
+		}
+	case "zset":
+		t = TypeZSet2
+		w.writeLength(uint64(len(v.ZSet)))
+		for _, m := range v.ZSet {
+			w.writeString(m.Member)
+			var score [8]byte
+			binary.LittleEndian.PutUint64(score[:], math.Float64bits(m.Score))
+			w.write(score[:])
+		}
+	default:
+		return "", ErrUnsupported
+	}
+
+	return Dump(t, b.Bytes(), version), w.err
+}
+
+// commandElements bounds the elements added by each command of Commands.
+const commandElements = 512
+
+// Commands returns the native commands creating key with the value,
+// replacing it: DEL, then SET, RPUSH, SADD, HSET, ZADD or XADD.
+func (v *Value) Commands(key string) [][]string {
+	cmds := [][]string{{"DEL", key}}
+
+	// add appends commands adding the elements, in chunks of n.
+	add := func(name string, elements []string, n int) {
+		for len(elements) > 0 {
+			size := commandElements - commandElements%n
+			if size > len(elements) {
+				size = len(elements)
+			}
+			cmd := append([]string{name, key}, elements[:size]...)
+			cmds = append(cmds, cmd)
+			elements = elements[size:]
+		}
+	}
+
+	switch v.Type {
+	case "string":
+		cmds = append(cmds, []string{"SET", key, v.String})
+	case "list":
+		add("RPUSH", v.Elements, 1)
+	case "set":
+		add("SADD", v.Elements, 1)
+	case "hash":
+		fields := make([]string, 0, len(v.Hash))
+		for field := range v.Hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		var elements []string
+		for _, field := range fields {
+			elements = append(elements, field, v.Hash[field])
+		}
+		add("HSET", elements, 2)
+	case "zset":
+		var elements []string
+		for _, m := range v.ZSet {
+			elements = append(elements, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+		}
+		add("ZADD", elements, 2)
+	case "stream":
+		for _, e := range v.Stream {
+			cmds = append(cmds, append([]string{"XADD", key, e.ID}, e.Fields...))
+		}
+	}
+
+	return cmds
+}
@@ -0,0 +1,107 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultVersion is the RDB version of files without DUMP payloads
+// to take the version from.
+const DefaultVersion = 9
+
+// Writer writes keys to an RDB file.
+type Writer struct {
+	w   io.Writer
+	crc uint64
+	err error
+}
+
+// NewWriter writes the header of an RDB file of version to w.
+func NewWriter(w io.Writer, version int) (*Writer, error) {
+	wr := &Writer{w: w}
+	wr.write([]byte(fmt.Sprintf("REDIS%04d", version)))
+	return wr, wr.err
+}
+
+// write writes p, updating the checksum.
+// After an error, writes are ignored and the error kept.
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = Checksum(w.crc, p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *Writer) writeLength(n uint64) {
+	var b [9]byte
+	switch {
+	case n < 1<<6:
+		w.write([]byte{byte(n)})
+	case n < 1<<14:
+		w.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= 1<<32-1:
+		b[0] = 0x80
+		binary.BigEndian.PutUint32(b[1:5], uint32(n))
+		w.write(b[:5])
+	default:
+		b[0] = 0x81
+		binary.BigEndian.PutUint64(b[1:], n)
+		w.write(b[:])
+	}
+}
+
+func (w *Writer) writeString(s string) {
+	w.writeLength(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+// SelectDB starts the keys of db.
+func (w *Writer) SelectDB(db int) error {
+	w.write([]byte{opSelectDB})
+	w.writeLength(uint64(db))
+	return w.err
+}
+
+// WriteAux writes an auxiliary field, such as redis-ver.
+func (w *Writer) WriteAux(key, value string) error {
+	w.write([]byte{opAux})
+	w.writeString(key)
+	w.writeString(value)
+	return w.err
+}
+
+// Write writes a key from its DUMP payload, expiring at expireAt
+// Unix milliseconds, or never with 0.
+func (w *Writer) Write(key, dump string, expireAt int64) error {
+	if len(dump) < 11 {
+		return fmt.Errorf("rdb: key %s: invalid dump payload", key)
+	}
+
+	if expireAt > 0 {
+		var b [9]byte
+		b[0] = opExpireTimeMs
+		binary.LittleEndian.PutUint64(b[1:], uint64(expireAt))
+		w.write(b[:])
+	}
+
+	// The type, then the key, then the object.
+	w.write([]byte{dump[0]})
+	w.writeString(key)
+	w.write([]byte(dump[1 : len(dump)-10]))
+
+	return w.err
+}
+
+// Close writes the end of the file and its checksum.
+// It doesn't close the underlying io.Writer.
+func (w *Writer) Close() error {
+	w.write([]byte{opEOF})
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], w.crc)
+	w.write(b[:])
+
+	return w.err
+}
@@ -0,0 +1,285 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+	"github.com/go-redis/redis/v8"
+)
+
+// follower collects the keys changed on the db, from keyspace notifications.
+// Keys are deduplicated until handled, bounding memory to the changed keys.
+// The notify-keyspace-events enabled on nodes are restored once closed.
+type follower struct {
+	subs    []*redis.PubSub
+	mu      sync.Mutex
+	pending map[string]struct{}
+	notify  chan struct{}
+	events  []nodeEvents
+	log     io.Writer
+}
+
+// nodeEvents are the notify-keyspace-events of a node before they were enabled.
+type nodeEvents struct {
+	node   *redis.Client
+	events string
+}
+
+// add marks a key as changed.
+func (f *follower) add(key string) {
+	f.mu.Lock()
+	f.pending[key] = struct{}{}
+	f.mu.Unlock()
+
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the changed keys, and forgets them.
+func (f *follower) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.pending))
+	for key := range f.pending {
+		keys = append(keys, key)
+	}
+	f.pending = map[string]struct{}{}
+
+	return keys
+}
+
+// Close closes the subscriptions, and restores the notify-keyspace-events
+// of the nodes.
+func (f *follower) Close() error {
+	for _, sub := range f.subs {
+		sub.Close()
+	}
+
+	// ctx may be done already.
+	ctx := context.Background()
+	for _, e := range f.events {
+		addr := e.node.Options().Addr
+		if err := e.node.ConfigSet(ctx, "notify-keyspace-events", e.events).Err(); err != nil {
+			fmt.Fprintf(f.log, "follow: can't restore notify-keyspace-events %q on %s: %s\n", e.events, addr, err)
+			continue
+		}
+		fmt.Fprintf(f.log, "follow: notify-keyspace-events %q restored on %s\n", e.events, addr)
+	}
+	return nil
+}
+
+// subscribe subscribes to the keyspace notifications of every master,
+// enabling them when the server permits CONFIG SET.
+// Notifications are collected right away, so that keys changed during
+// the initial scan are not missed.
+func (r *Redis) subscribe(ctx context.Context) (*follower, error) {
+	f := &follower{
+		pending: map[string]struct{}{},
+		notify:  make(chan struct{}, 1),
+		log:     r.Log,
+	}
+
+	var nodes []*redis.Client
+	switch c := r.client.(type) {
+	case *redis.ClusterClient:
+		var mu sync.Mutex
+		err := c.ForEachMaster(ctx, func(_ context.Context, node *redis.Client) error {
+			mu.Lock()
+			nodes = append(nodes, node)
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	case *redis.Client:
+		nodes = append(nodes, c)
+	default:
+		return nil, fmt.Errorf("follow: unsupported client %T", r.client)
+	}
+
+	for _, node := range nodes {
+		r.enableNotifications(ctx, f, node)
+
+		prefix := "__keyspace@" + strconv.Itoa(node.Options().DB) + "__:"
+		match := r.Filter.ScanMatch()
+		if match == "" {
+			match = "*"
+		}
+
+		sub := node.PSubscribe(ctx, prefix+match)
+		// Wait for the subscription confirmation.
+		if _, err := sub.Receive(ctx); err != nil {
+			sub.Close()
+			f.Close()
+			return nil, err
+		}
+		f.subs = append(f.subs, sub)
+
+		go func(ch <-chan *redis.Message) {
+			for msg := range ch {
+				key := strings.TrimPrefix(msg.Channel, prefix)
+				if r.Filter.MatchKey(key) {
+					f.add(key)
+				}
+			}
+		}(sub.Channel())
+	}
+
+	return f, nil
+}
+
+// enableNotifications enables the keyspace notifications of all commands
+// on a node, keeping the events already enabled, for f to restore them.
+// Managed services often deny CONFIG, the events must then be enabled
+// by their own means.
+func (r *Redis) enableNotifications(ctx context.Context, f *follower, node *redis.Client) {
+	addr := node.Options().Addr
+	res, err := node.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil || len(res) != 2 {
+		fmt.Fprintf(r.Log, "follow: can't check notify-keyspace-events on %s: %v\n", addr, err)
+		return
+	}
+
+	events, _ := res[1].(string)
+	flags := events
+	if !strings.Contains(flags, "K") {
+		flags += "K"
+	}
+	if !strings.Contains(flags, "A") {
+		flags += "A"
+	}
+	if flags == events {
+		return
+	}
+
+	if err := node.ConfigSet(ctx, "notify-keyspace-events", flags).Err(); err != nil {
+		fmt.Fprintf(r.Log, "follow: can't enable notify-keyspace-events on %s: %s\n", addr, err)
+		return
+	}
+	fmt.Fprintf(r.Log, "follow: notify-keyspace-events set to %q on %s until exit\n", flags, addr)
+	f.events = append(f.events, nodeEvents{node, events})
+}
+
+// follow pushes the keys changed on the db to the message Bus,
+// as Payloads or deleted Payloads, until ctx is done.
+func (r *Redis) follow(ctx context.Context, f *follower) error {
+	fmt.Fprintln(r.Log)
+	fmt.Fprintln(r.Log, "redis read: following changes")
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(r.Log)
+			fmt.Fprintln(r.Log, "redis read: exit")
+			return ctx.Err()
+		case <-f.notify:
+		}
+
+		keys := f.take()
+		failed := false
+		for start := 0; start < len(keys); start += r.Batch {
+			end := start + r.Batch
+			if end > len(keys) {
+				end = len(keys)
+			}
+			before := atomic.LoadInt64(&r.failed)
+			if err := r.dumpChanged(ctx, keys[start:end]); err != nil {
+				return err
+			}
+			// Keys failing to be read are read again, instead of staying
+			// stale on the target until they change again.
+			if atomic.LoadInt64(&r.failed) > before {
+				for _, key := range keys[start:end] {
+					f.add(key)
+				}
+				failed = true
+			}
+		}
+
+		if failed {
+			select {
+			case <-ctx.Done():
+				fmt.Fprintln(r.Log)
+				fmt.Fprintln(r.Log, "redis read: exit")
+				return ctx.Err()
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// dumpChanged dumps changed keys with a single pipeline of DUMP and PTTL,
+// and pushes the Payloads to the message Bus.
+// Keys missing from the db are pushed as deleted Payloads, keys failing
+// to be read are counted.
+func (r *Redis) dumpChanged(ctx context.Context, keys []string) error {
+	if r.Logical {
+		return r.dumpLogical(ctx, nil, keys, true)
+	}
+
+	start := time.Now()
+	dumps := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	err := r.exec(ctx, func(pipe redis.Pipeliner) {
+		for i, key := range keys {
+			dumps[i] = pipe.Dump(ctx, key)
+			if r.TTL {
+				ttls[i] = pipe.PTTL(ctx, key)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		p := message.Payload{Key: key}
+
+		value, err := dumps[i].Result()
+		switch {
+		case err == redis.Nil:
+			p.Deleted = true
+		case err != nil:
+			r.keyError(nil, key, err, start)
+			continue
+		default:
+			if !r.Filter.MatchType(rdb.DumpType(value)) {
+				continue
+			}
+
+			ttl, expireAt, ok, err := r.maybeTTL(ttls[i])
+			if err != nil {
+				r.keyError(nil, key, err, start)
+				continue
+			}
+			if ok {
+				p.Value, p.Ttl, p.ExpireAt = value, ttl, expireAt
+			} else {
+				p.Deleted = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(r.Log)
+			fmt.Fprintln(r.Log, "redis read: exit")
+			return ctx.Err()
+		case r.Bus <- p:
+			r.maybeLog("r")
+		}
+	}
+
+	return nil
+}
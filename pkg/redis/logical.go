@@ -0,0 +1,288 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/domwong/rump/pkg/checkpoint"
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+	"github.com/go-redis/redis/v8"
+)
+
+// fetchCount is the number of elements fetched per command by fetch.
+const fetchCount = 1000
+
+// versionMismatch reports whether err is RESTORE rejecting a DUMP payload,
+// as Redis does with payloads of newer RDB versions.
+func versionMismatch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "DUMP payload version or checksum are wrong")
+}
+
+// transaction reports whether p opens or closes the transaction of a key,
+// as the MULTI and EXEC Payloads around the commands of dumpLogical.
+func transaction(p message.Payload) (multi, exec bool) {
+	if len(p.Command) != 1 {
+		return false, false
+	}
+	name := strings.ToLower(p.Command[0])
+	return name == "multi", name == "exec"
+}
+
+// commands returns the command Payloads rebuilding key with v,
+// expiring at expireAt if set, otherwise after ttl milliseconds if set.
+func commands(key string, v *rdb.Value, ttl, expireAt int64) []message.Payload {
+	cmds := v.Commands(key)
+	if expireAt > 0 {
+		cmds = append(cmds, []string{"PEXPIREAT", key, strconv.FormatInt(expireAt, 10)})
+	} else if ttl > 0 {
+		cmds = append(cmds, []string{"PEXPIRE", key, strconv.FormatInt(ttl, 10)})
+	}
+
+	payloads := make([]message.Payload, len(cmds))
+	for i, cmd := range cmds {
+		payloads[i] = message.Payload{Key: key, Command: cmd}
+	}
+	return payloads
+}
+
+// dumpLogical reads keys with type-specific commands instead of DUMP,
+// and pushes the command Payloads rebuilding them to the message Bus,
+// between MULTI and EXEC Payloads so that each key is rebuilt atomically.
+// With AbsTTL keys expire at their absolute expire time.
+// Values without a logical form, like modules, are still dumped.
+// Keys missing from the db are pushed as deleted Payloads with deleted,
+// skipped otherwise. Errors on single keys of page are reported with keyError.
+func (r *Redis) dumpLogical(ctx context.Context, page *checkpoint.Page, keys []string, deleted bool) error {
+	start := time.Now()
+	types := make([]*redis.StatusCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	err := r.exec(ctx, func(pipe redis.Pipeliner) {
+		for i, key := range keys {
+			types[i] = pipe.Type(ctx, key)
+			if r.TTL {
+				ttls[i] = pipe.PTTL(ctx, key)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		t, err := types[i].Result()
+		if err != nil {
+			r.keyError(page, key, err, start)
+			continue
+		}
+		if t != "none" && !r.Filter.MatchType(t) {
+			continue
+		}
+
+		ttl, expireAt, ok, err := r.maybeTTL(ttls[i])
+		if err != nil {
+			r.keyError(page, key, err, start)
+			continue
+		}
+
+		var payloads []message.Payload
+		var v *rdb.Value
+		if t != "none" && ok {
+			v, err = r.fetch(ctx, key, t)
+		}
+		switch {
+		case err == rdb.ErrUnsupported:
+			value, err := r.client.Dump(ctx, key).Result()
+			if err != nil && err != redis.Nil {
+				r.keyError(page, key, err, start)
+				continue
+			}
+			if err == nil {
+				payloads = []message.Payload{{Key: key, Value: value, Ttl: ttl}}
+			}
+		case err != nil:
+			r.keyError(page, key, err, start)
+			continue
+		case v != nil:
+			ms, _ := strconv.ParseInt(ttl, 10, 64)
+			if !r.AbsTTL {
+				expireAt = 0
+			}
+			payloads = append([]message.Payload{{Key: key, Command: []string{"MULTI"}}}, commands(key, v, ms, expireAt)...)
+			payloads = append(payloads, message.Payload{Key: key, Command: []string{"EXEC"}})
+		case deleted:
+			payloads = []message.Payload{{Key: key, Deleted: true}}
+		}
+
+		for _, p := range payloads {
+			select {
+			case <-ctx.Done():
+				fmt.Fprintln(r.Log)
+				fmt.Fprintln(r.Log, "redis read: exit")
+				return ctx.Err()
+			case r.Bus <- p:
+			}
+		}
+		if len(payloads) > 0 {
+			r.maybeLog("r")
+		}
+	}
+
+	return nil
+}
+
+// fetch reads the value of key of type t with GET, LRANGE, SSCAN, HSCAN,
+// ZSCAN or XRANGE, fetchCount elements at a time.
+// It returns nil if the key was deleted meanwhile, and rdb.ErrUnsupported
+// for types without a logical form.
+func (r *Redis) fetch(ctx context.Context, key, t string) (*rdb.Value, error) {
+	v := &rdb.Value{Type: t}
+
+	// scan collects the elements of a SCAN family command.
+	scan := func(cmd func(cursor uint64) *redis.ScanCmd) ([]string, error) {
+		var elements []string
+		var cursor uint64
+		for {
+			page, next, err := cmd(cursor).Result()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, page...)
+			if cursor = next; cursor == 0 {
+				return elements, nil
+			}
+		}
+	}
+
+	var err error
+	switch t {
+	case "string":
+		v.String, err = r.client.Get(ctx, key).Result()
+	case "list":
+		for start := int64(0); ; start += fetchCount {
+			var page []string
+			if page, err = r.client.LRange(ctx, key, start, start+fetchCount-1).Result(); err != nil {
+				break
+			}
+			v.Elements = append(v.Elements, page...)
+			if len(page) < fetchCount {
+				break
+			}
+		}
+	case "set":
+		v.Elements, err = scan(func(cursor uint64) *redis.ScanCmd {
+			return r.client.SScan(ctx, key, cursor, "", fetchCount)
+		})
+	case "hash":
+		var fields []string
+		fields, err = scan(func(cursor uint64) *redis.ScanCmd {
+			return r.client.HScan(ctx, key, cursor, "", fetchCount)
+		})
+		v.Hash = make(map[string]string, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			v.Hash[fields[i]] = fields[i+1]
+		}
+	case "zset":
+		var members []string
+		members, err = scan(func(cursor uint64) *redis.ScanCmd {
+			return r.client.ZScan(ctx, key, cursor, "", fetchCount)
+		})
+		for i := 0; err == nil && i+1 < len(members); i += 2 {
+			var score float64
+			score, err = strconv.ParseFloat(members[i+1], 64)
+			v.ZSet = append(v.ZSet, rdb.Member{Member: members[i], Score: score})
+		}
+	case "stream":
+		err = r.fetchStream(ctx, key, v)
+	default:
+		return nil, rdb.ErrUnsupported
+	}
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Deleted meanwhile, or emptied as a stream.
+	if t != "string" && len(v.Elements)+len(v.Hash)+len(v.ZSet)+len(v.Stream) == 0 {
+		if t == "stream" {
+			return nil, rdb.ErrUnsupported
+		}
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+// fetchStream reads the entries of a stream with XRANGE, keeping the order
+// of their fields. Each page starts after the last entry of the previous one,
+// computed since exclusive ranges require Redis >= 6.2.
+func (r *Redis) fetchStream(ctx context.Context, key string, v *rdb.Value) error {
+	start := "-"
+	for {
+		reply, err := r.client.Do(ctx, "xrange", key, start, "+", "count", fetchCount).Result()
+		if err != nil {
+			return err
+		}
+		res, _ := reply.([]interface{})
+
+		for _, entry := range res {
+			e, ok := entry.([]interface{})
+			if !ok || len(e) != 2 {
+				return fmt.Errorf("unexpected stream entry %v", entry)
+			}
+			id, _ := e[0].(string)
+			fields, _ := e[1].([]interface{})
+			se := rdb.StreamEntry{ID: id}
+			for _, field := range fields {
+				se.Fields = append(se.Fields, fmt.Sprint(field))
+			}
+			v.Stream = append(v.Stream, se)
+		}
+		if len(res) < fetchCount {
+			return nil
+		}
+
+		last := v.Stream[len(v.Stream)-1].ID
+		i := strings.IndexByte(last, '-')
+		if i < 0 {
+			return fmt.Errorf("invalid stream id %s", last)
+		}
+		seq, err := strconv.ParseUint(last[i+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid stream id %s", last)
+		}
+		start = last[:i+1] + strconv.FormatUint(seq+1, 10)
+	}
+}
+
+// restoreLogical restores a Payload whose DUMP payload was rejected,
+// decoding it and rebuilding the key with native commands in a transaction.
+// With AbsTTL the key expires at the Payload absolute expire time,
+// otherwise the Payload relative TTL is used.
+func (r *Redis) restoreLogical(ctx context.Context, p message.Payload) error {
+	v, err := rdb.Decode(p.Value)
+	if err != nil {
+		return err
+	}
+
+	ttl, _ := strconv.ParseInt(p.Ttl, 10, 64)
+	var expireAt int64
+	if r.AbsTTL {
+		expireAt = p.ExpireAt
+	}
+
+	return r.retry(ctx, func() error {
+		_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, c := range commands(p.Key, v, ttl, expireAt) {
+				r.restore(ctx, pipe, c)
+			}
+			return nil
+		})
+		return err
+	})
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Mirror deletes the db keys selected by the Filter that are not in Seen,
+// the keys written from the message bus.
+// To be called once the whole source was read and written without errors:
+// a source key failing to be read is missing from Seen, and would be deleted.
+// With MirrorDryRun the keys are listed instead of deleted.
+// Nothing is deleted when there are more than MirrorLimit keys to delete,
+// unless MirrorLimit is 0.
+func (r *Redis) Mirror(ctx context.Context) error {
+	var mu sync.Mutex
+	var keys []string
+	err := r.walk(ctx, func(page []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, key := range page {
+			if r.Seen.Has(key) {
+				continue
+			}
+			if r.MirrorDryRun {
+				fmt.Fprintf(r.Log, "mirror: would delete %s\n", key)
+			}
+			keys = append(keys, key)
+		}
+
+		if !r.MirrorDryRun && r.MirrorLimit > 0 && len(keys) > r.MirrorLimit {
+			return fmt.Errorf("mirror: more than %d keys to delete, aborting", r.MirrorLimit)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.MirrorDryRun {
+		fmt.Fprintf(r.Log, "mirror: %d keys would be deleted\n", len(keys))
+		return nil
+	}
+
+	for start := 0; start < len(keys); start += r.WriteBatch {
+		end := start + r.WriteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := r.delete(ctx, keys[start:end]); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(r.Log, "mirror: deleted %d keys\n", len(keys))
+
+	return nil
+}
+
+// delete deletes a batch of keys with a single pipeline.
+// With a Redis Cluster each DEL is sent to the node owning the key slot.
+func (r *Redis) delete(ctx context.Context, keys []string) error {
+	cmds := make([]*redis.IntCmd, len(keys))
+	err := r.exec(ctx, func(pipe redis.Pipeliner) {
+		for i, key := range keys {
+			cmds[i] = pipe.Del(ctx, key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if err := cmds[i].Err(); err != nil {
+			return fmt.Errorf("key %s with error %s", key, err)
+		}
+		r.maybeLog("d")
+	}
+
+	return nil
+}
@@ -4,142 +4,708 @@ package redis
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/domwong/rump/pkg/checkpoint"
+	"github.com/domwong/rump/pkg/filter"
+	"github.com/domwong/rump/pkg/keyset"
 	"github.com/domwong/rump/pkg/message"
 	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/errgroup"
 )
 
 // Redis holds references to a DB pool and a shared message bus.
+// The DB pool is either a single node client or a Redis Cluster client.
 // Silent disables verbose mode.
 // TTL enables TTL sync.
+// AbsTTL restores TTLs as absolute expire times, also read as such with Logical.
+// FailoverTimeout retries failing commands while a new master is elected,
+// used with Sentinel clients re-resolving the master on failover.
+// Filter selects the keys to read.
+// Batch is the number of keys per SCAN page, dumped with a single pipeline.
+// Depth is the number of dump pipelines in flight.
+// Writers is the number of goroutines restoring keys.
+// WriteBatch is the maximum number of keys restored with a single pipeline.
+// Checkpoint, if set, tracks the progress of reads and writes.
+// VerifyReport is the file Verify writes Differences to.
+// TTLWindow is the tolerance of Verify comparing expire times.
+// Seen, if set, collects the keys written, for Mirror to delete the others.
+// MirrorDryRun lists the keys Mirror would delete, MirrorLimit caps them.
+// Follow keeps reading the keys changed after the initial scan,
+// from keyspace notifications, until the context is done.
+// Logical reads values with type-specific commands instead of DUMP,
+// for targets to rebuild them with native commands.
+// Log is where progress and status messages are printed, os.Stdout by
+// default.
 type Redis struct {
-	client *redis.Client
+	// failed counts the keys which failed to be read, first for 64-bit
+	// atomic alignment.
+	failed int64
+	client redis.UniversalClient
 	//Pool   *radix.Pool
-	Bus    message.Bus
-	Silent bool
-	TTL    bool
+	Bus             message.Bus
+	Silent          bool
+	TTL             bool
+	AbsTTL          bool
+	FailoverTimeout time.Duration
+	Filter          filter.Filter
+	Batch           int
+	Depth           int
+	Writers         int
+	WriteBatch      int
+	Checkpoint      *checkpoint.Checkpoint
+	VerifyReport    string
+	TTLWindow       time.Duration
+	Seen            *keyset.Set
+	MirrorDryRun    bool
+	MirrorLimit     int
+	Follow          bool
+	Logical         bool
+	Log             io.Writer
+	// fallback reports once the use of native commands by restoreBatch.
+	fallback sync.Once
 }
 
 // New creates the Redis struct, used to read/write.
-func New(source *redis.Client, bus message.Bus, silent, ttl bool) *Redis {
+// source can be a *redis.Client or a *redis.ClusterClient.
+func New(source redis.UniversalClient, bus message.Bus, silent, ttl bool) *Redis {
 	return &Redis{
-		client: source,
-		Bus:    bus,
-		Silent: silent,
-		TTL:    ttl,
+		client:     source,
+		Bus:        bus,
+		Silent:     silent,
+		TTL:        ttl,
+		Batch:      400,
+		Depth:      4,
+		Writers:    4,
+		WriteBatch: 100,
+		TTLWindow:  5 * time.Second,
+		Log:        os.Stdout,
 	}
 }
 
+// keyError reports the error of a key failing to be read, and counts it
+// to fail the Read. The Checkpoint never moves past its page.
+func (r *Redis) keyError(page *checkpoint.Page, key string, err error, start time.Time) {
+	atomic.AddInt64(&r.failed, 1)
+	page.Fail()
+	fmt.Fprintf(r.Log, "key %s with error %s after %s\n", key, err, time.Since(start))
+}
+
 // maybeLog may log, depending on the Silent flag
 func (r *Redis) maybeLog(s string) {
 	if r.Silent {
 		return
 	}
-	fmt.Print(s)
+	fmt.Fprint(r.Log, s)
 }
 
-// maybeTTL may sync the TTL, depending on the TTL flag
-func (r *Redis) maybeTTL(key string) (string, error) {
+// maybeTTL may sync the TTL, depending on the TTL flag.
+// cmd is the pipelined PTTL of the key, nil if TTL is disabled.
+// It returns the remaining TTL in milliseconds, "0" meaning no expiration,
+// and the absolute expire time in Unix milliseconds, 0 meaning no expiration.
+// ok is false when the key expired or was deleted after being scanned.
+func (r *Redis) maybeTTL(cmd *redis.DurationCmd) (ttl string, expireAt int64, ok bool, err error) {
 	// noop if TTL is disabled, speeds up sync process
 	if !r.TTL {
-		return "0", nil
+		return "0", 0, true, nil
 	}
 
-	var ttl string
-
-	// Try getting key TTL.
-	res, err := r.client.PTTL(context.Background(), key).Result()
+	res, err := cmd.Result()
 	if err != nil && err != redis.Nil {
-		return ttl, err
+		return "", 0, false, err
 	}
 
+	switch {
+	// When key does not exist anymore PTTL returns "-2".
+	case res == time.Duration(-2):
+		return "", 0, false, nil
 	// When key has no expire PTTL returns "-1".
 	// We set it to 0, default for no expiration time.
-	if res == time.Duration(-1) {
-		ttl = "0"
+	case res < 0:
+		return "0", 0, true, nil
 	}
 
-	return ttl, nil
+	// A key about to expire still has a TTL, never turn it into 0.
+	ms := int64(res / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	expireAt = time.Now().UnixNano()/int64(time.Millisecond) + ms
+
+	return strconv.FormatInt(ms, 10), expireAt, true, nil
+}
+
+// restore queues the RESTORE of a Payload on a pipeline.
+// With AbsTTL the key expires at the Payload absolute expire time,
+// otherwise the Payload relative TTL is used.
+// Deleted Payloads are deleted, command Payloads run as is.
+func (r *Redis) restore(ctx context.Context, pipe redis.Pipeliner, p message.Payload) redis.Cmder {
+	if p.Deleted {
+		return pipe.Del(ctx, p.Key)
+	}
+	// The transaction is the pipeline itself, see restoreBatch.
+	if multi, exec := transaction(p); multi || exec {
+		return redis.NewStatusResult("OK", nil)
+	}
+	if len(p.Command) > 0 {
+		args := make([]interface{}, len(p.Command))
+		for i, arg := range p.Command {
+			args[i] = arg
+		}
+		return pipe.Do(ctx, args...)
+	}
+
+	if r.AbsTTL && p.ExpireAt > 0 {
+		return pipe.Do(ctx, "restore", p.Key, p.ExpireAt, p.Value, "replace", "absttl")
+	}
+
+	ttl, _ := strconv.ParseInt(p.Ttl, 10, 64)
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return pipe.RestoreReplace(ctx, p.Key, time.Duration(ttl)*time.Millisecond, p.Value)
 }
 
 // Read gently scans an entire Redis DB for keys, then dumps
 // the key/value pair (Payload) on the message Bus channel.
-// It leverages implicit pipelining to speedup large DB reads.
+// With a Redis Cluster every master is scanned in parallel.
+// It pipelines DUMP and PTTL commands to speedup large DB reads.
+// With Follow, the keys changed since the start of the scan are then
+// pushed until ctx is done.
 // To be used in an ErrGroup.
 func (r *Redis) Read(ctx context.Context) error {
 	defer close(r.Bus)
 
-	var cursor uint64 = 0
+	if !r.Follow {
+		return r.scanAll(ctx)
+	}
 
-	var ttl string
+	f, err := r.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	// Scan and push to bus until no keys are left.
-	// If context Done, exit early.
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = r.client.Scan(ctx, cursor, "", 400).Result()
-		if err != nil && err != redis.Nil {
+	if err := r.scanAll(ctx); err != nil {
+		return err
+	}
+
+	return r.follow(ctx, f)
+}
+
+// scanAll scans every master, failing if some keys couldn't be read.
+func (r *Redis) scanAll(ctx context.Context) error {
+	if err := r.scanMasters(ctx); err != nil {
+		return err
+	}
+
+	if n := atomic.LoadInt64(&r.failed); n > 0 {
+		return fmt.Errorf("redis read: %d keys failed to be read, the sync is incomplete", n)
+	}
+	return nil
+}
+
+// scanMasters scans every master.
+func (r *Redis) scanMasters(ctx context.Context) error {
+	if c, ok := r.client.(*redis.ClusterClient); ok {
+		g, gctx := errgroup.WithContext(ctx)
+		err := c.ForEachMaster(ctx, func(_ context.Context, node *redis.Client) error {
+			g.Go(func() error {
+				return r.scan(gctx, node.Options().Addr, node)
+			})
+			return nil
+		})
+		if err != nil {
 			return err
 		}
-		for _, key := range keys {
-			start := time.Now()
-			value, err := r.client.Dump(ctx, key).Result()
-			if err != nil {
-				fmt.Printf("key %s with error %s after %s\n", key, err, time.Since(start))
-				continue
+		return g.Wait()
+	}
+
+	return r.scan(ctx, "", r.client)
+}
+
+// batch is a page of scanned keys, dumped with a single pipeline.
+// checkType is set when the type filter could not be applied by SCAN.
+// page tracks the batch keys for the Checkpoint.
+type batch struct {
+	keys      []string
+	checkType bool
+	page      *checkpoint.Page
+}
+
+// exec runs a pipeline filled by fn.
+// Errors replied to single commands are left to the caller,
+// the whole pipeline is retried only on connection errors.
+func (r *Redis) exec(ctx context.Context, fn func(pipe redis.Pipeliner)) error {
+	return r.retry(ctx, func() error {
+		return r.execOnce(ctx, fn)
+	})
+}
+
+// execOnce runs a pipeline once, without retrying it.
+func (r *Redis) execOnce(ctx context.Context, fn func(pipe redis.Pipeliner)) error {
+	return execPipe(ctx, r.client.Pipeline(), fn)
+}
+
+// execTx runs a pipeline once as a transaction, without retrying it.
+func (r *Redis) execTx(ctx context.Context, fn func(pipe redis.Pipeliner)) error {
+	return execPipe(ctx, r.client.TxPipeline(), fn)
+}
+
+// execPipe runs pipe with the commands queued by fn.
+func execPipe(ctx context.Context, pipe redis.Pipeliner, fn func(pipe redis.Pipeliner)) error {
+	fn(pipe)
+	_, err := pipe.Exec(ctx)
+	if _, ok := err.(redis.Error); ok {
+		return nil
+	}
+	return err
+}
+
+// scanPage scans a page of keys, filtered by pattern and type on the server
+// when possible. scanType is reset when the server doesn't support SCAN TYPE.
+// master is the run ID of the node which issued cursor, set on the first page.
+// Cursors are only meaningful on the node which issued them: when SCAN is
+// retried on a new master after a failover, or on a node which can't be
+// identified, the page is scanned again from cursor 0, and restarted is set.
+func (r *Redis) scanPage(ctx context.Context, node redis.Cmdable, cursor uint64, match string, scanType, master *string) (keys []string, next uint64, restarted bool, err error) {
+	if *master == "" {
+		*master = runID(ctx, node)
+	}
+
+	attempts := 0
+	err = r.retry(ctx, func() error {
+		attempts++
+		if attempts > 1 && cursor != 0 {
+			if id := runID(ctx, node); id == "" || id != *master {
+				*master = id
+				cursor = 0
+				restarted = true
 			}
+		}
 
-			ttl, err = r.maybeTTL(key)
-			if err != nil {
+		var err error
+		if *scanType != "" {
+			keys, next, err = node.ScanType(ctx, cursor, match, int64(r.Batch), *scanType).Result()
+			// SCAN TYPE requires Redis >= 6.0, fall back to TYPE.
+			if !unsupported(err) {
 				return err
 			}
-			select {
-			case <-ctx.Done():
-				fmt.Println("")
-				fmt.Println("redis read: exit")
-				return ctx.Err()
-			case r.Bus <- message.Payload{Key: key, Value: value, Ttl: ttl}:
-				r.maybeLog("r")
+			*scanType = ""
+		}
+		keys, next, err = node.Scan(ctx, cursor, match, int64(r.Batch)).Result()
+		return err
+	})
+
+	return keys, next, restarted, err
+}
+
+// runID returns the run ID of node, changing with the master after a
+// failover, or "" if it can't be read.
+func runID(ctx context.Context, node redis.Cmdable) string {
+	info, err := node.Info(ctx, "server").Result()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "run_id:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "run_id:"))
+		}
+	}
+	return ""
+}
+
+// unsupported reports whether err is the server rejecting a command syntax,
+// as older versions do with newer options.
+func unsupported(err error) bool {
+	if _, ok := err.(redis.Error); !ok || err == redis.Nil {
+		return false
+	}
+	return strings.HasPrefix(err.Error(), "ERR ")
+}
+
+// matchTypes returns the keys whose type is selected by the Filter,
+// using a pipeline of TYPE commands.
+// Keys deleted after being scanned have type "none", never selected.
+// Errors on single keys of page are reported with keyError.
+func (r *Redis) matchTypes(ctx context.Context, page *checkpoint.Page, keys []string) ([]string, error) {
+	start := time.Now()
+	types := make([]*redis.StatusCmd, len(keys))
+	err := r.exec(ctx, func(pipe redis.Pipeliner) {
+		for i, key := range keys {
+			types[i] = pipe.Type(ctx, key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for i, key := range keys {
+		t, err := types[i].Result()
+		if err != nil {
+			r.keyError(page, key, err, start)
+			continue
+		}
+		if r.Filter.MatchType(t) {
+			matches = append(matches, key)
+		}
+	}
+
+	return matches, nil
+}
+
+// dump dumps a batch of keys with a single pipeline of DUMP and PTTL,
+// and pushes the Payloads to the message Bus.
+// Errors on single keys are reported on their own, the keys skipped,
+// and fail the Read once all keys were scanned.
+func (r *Redis) dump(ctx context.Context, b batch) error {
+	defer b.page.Close()
+
+	keys := b.keys
+	if b.checkType {
+		var err error
+		keys, err = r.matchTypes(ctx, b.page, keys)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Logical {
+		return r.dumpLogical(ctx, b.page, keys, false)
+	}
+
+	start := time.Now()
+	dumps := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	err := r.exec(ctx, func(pipe redis.Pipeliner) {
+		for i, key := range keys {
+			dumps[i] = pipe.Dump(ctx, key)
+			if r.TTL {
+				ttls[i] = pipe.PTTL(ctx, key)
 			}
+		}
+	})
+	if err != nil {
+		return err
+	}
 
+	for i, key := range keys {
+		value, err := dumps[i].Result()
+		// Key expired or deleted after being scanned.
+		if err == redis.Nil {
+			continue
 		}
-		if cursor == 0 {
-			return nil
+		if err != nil {
+			r.keyError(b.page, key, err, start)
+			continue
+		}
+
+		ttl, expireAt, ok, err := r.maybeTTL(ttls[i])
+		if err != nil {
+			r.keyError(b.page, key, err, start)
+			continue
+		}
+		// Key expired between DUMP and PTTL, don't restore it as persistent.
+		if !ok {
+			continue
+		}
+
+		b.page.Emit(key)
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(r.Log)
+			fmt.Fprintln(r.Log, "redis read: exit")
+			return ctx.Err()
+		case r.Bus <- message.Payload{Key: key, Value: value, Ttl: ttl, ExpireAt: expireAt}:
+			r.maybeLog("r")
 		}
 	}
 
 	return nil
 }
 
+// scan scans a single node for keys and pushes Payloads to the message Bus.
+// Pages of keys are dumped by Depth pipelines in flight.
+// Only SCAN is sent to node, DUMP and PTTL go through the Redis client,
+// so that a Redis Cluster routes them to the node owning the key slot.
+// The SCAN resumes from the Checkpoint cursor of name, if any.
+func (r *Redis) scan(ctx context.Context, name string, node redis.Cmdable) error {
+	cursor, done := r.Checkpoint.Cursor(name)
+	if done {
+		return nil
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	batches := make(chan batch, r.Depth)
+
+	// Scan until no keys are left, handing pages to the dump pipelines.
+	// If context Done, exit early.
+	g.Go(func() error {
+		defer close(batches)
+
+		match := r.Filter.ScanMatch()
+		scanType := r.Filter.ScanType()
+		var master string
+
+		for {
+			keys, next, restarted, err := r.scanPage(gctx, node, cursor, match, &scanType, &master)
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if restarted {
+				fmt.Fprintln(r.Log, "\nredis: new master, SCAN restarted from cursor 0")
+			}
+
+			b := batch{
+				// Types not filtered by SCAN TYPE are checked with TYPE.
+				checkType: scanType == "" && len(r.Filter.Types) > 0,
+				page:      r.Checkpoint.Add(name, next),
+			}
+			for _, key := range keys {
+				if r.Filter.MatchKey(key) {
+					b.keys = append(b.keys, key)
+				}
+			}
+
+			if len(b.keys) == 0 {
+				b.page.Close()
+			} else {
+				select {
+				case <-gctx.Done():
+					return gctx.Err()
+				case batches <- b:
+				}
+			}
+
+			cursor = next
+			if cursor == 0 {
+				return nil
+			}
+		}
+	})
+
+	for i := 0; i < r.Depth; i++ {
+		g.Go(func() error {
+			for b := range batches {
+				if err := r.dump(gctx, b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
 // Write restores keys on the db as they come on the message bus.
+// Writers goroutines drain the bus, each sending pipelines of up to
+// WriteBatch RESTOREs.
+// With a Redis Cluster each RESTORE is sent to the node owning the key slot.
 func (r *Redis) Write(ctx context.Context) error {
-	// Loop until channel is open
-	for r.Bus != nil {
+	err := r.drain(ctx, r.restoreBatch)
+	if ctx.Err() != nil {
+		fmt.Fprintln(r.Log)
+		fmt.Fprintln(r.Log, "redis write: exit")
+	}
+
+	return err
+}
+
+// drain runs Writers goroutines collecting batches of up to WriteBatch
+// Payloads from the message bus, handled by handle.
+// The Payloads of a key always go to the same goroutine, in order.
+func (r *Redis) drain(ctx context.Context, handle func(context.Context, []message.Payload) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+
+	shards := make([]chan message.Payload, r.Writers)
+	for i := range shards {
+		shard := make(chan message.Payload, r.WriteBatch)
+		shards[i] = shard
+		g.Go(func() error {
+			return r.collect(gctx, shard, handle)
+		})
+	}
+
+	g.Go(func() error {
+		defer func() {
+			for _, shard := range shards {
+				close(shard)
+			}
+		}()
+
+		for {
+			select {
+			case <-gctx.Done():
+				return gctx.Err()
+			case p, ok := <-r.Bus:
+				if !ok {
+					return nil
+				}
+				h := fnv.New32a()
+				h.Write([]byte(p.Key))
+				select {
+				case <-gctx.Done():
+					return gctx.Err()
+				case shards[h.Sum32()%uint32(len(shards))] <- p:
+				}
+			}
+		}
+	})
+
+	return g.Wait()
+}
+
+// collect collects batches of Payloads from a shard of the message bus and
+// handles them, until the shard is closed or the context done.
+func (r *Redis) collect(ctx context.Context, shard <-chan message.Payload, handle func(context.Context, []message.Payload) error) error {
+	for {
+		var b []message.Payload
+		// open is the index in b of the MULTI of a transaction waiting for
+		// its EXEC, -1 if none.
+		open := -1
+		add := func(p message.Payload) {
+			switch multi, exec := transaction(p); {
+			case multi:
+				open = len(b)
+			case exec:
+				open = -1
+			}
+			b = append(b, p)
+		}
+
+		// Wait for the first Payload of a batch.
 		select {
 		// Exit early if context done.
 		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("redis write: exit")
 			return ctx.Err()
-		// Get Messages from Bus
-		case p, ok := <-r.Bus:
-			// if channel closed, set to nil, break loop
+		case p, ok := <-shard:
 			if !ok {
-				r.Bus = nil
+				return nil
+			}
+			add(p)
+		}
+
+		// Fill the batch with the Payloads already waiting on the shard,
+		// and with the rest of an open transaction, never split.
+	fill:
+		for len(b) < r.WriteBatch || open >= 0 {
+			if open >= 0 {
+				select {
+				case <-ctx.Done():
+					break fill
+				case p, ok := <-shard:
+					if !ok {
+						break fill
+					}
+					add(p)
+				}
 				continue
 			}
-			ttl, _ := strconv.Atoi(p.Ttl)
-			if ttl < 0 {
-				ttl = 0
+			select {
+			case p, ok := <-shard:
+				if !ok {
+					break fill
+				}
+				add(p)
+			default:
+				break fill
 			}
-			if err := r.client.RestoreReplace(ctx, p.Key, time.Duration(ttl)*time.Millisecond, p.Value).Err(); err != nil {
+		}
+
+		// A transaction interrupted by an exit is dropped: its key is left
+		// unchanged rather than partially rebuilt.
+		var interrupted error
+		if open >= 0 {
+			interrupted = fmt.Errorf("transaction of key %s interrupted, key not restored", b[open].Key)
+			fmt.Fprintf(r.Log, "\nredis write: %s\n", interrupted)
+			b = b[:open]
+		}
+
+		if len(b) > 0 {
+			if err := handle(ctx, b); err != nil {
 				return err
 			}
-			r.maybeLog("w")
 		}
+		if interrupted != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return interrupted
+		}
+	}
+}
+
+// restoreBatch restores a batch of Payloads with a single pipeline.
+// Once sent, a batch is always completed, even if ctx is done meanwhile,
+// and keys failing to restore are reported one by one.
+// DUMP payloads rejected by the db, like those of newer Redis versions,
+// are restored again with native commands.
+func (r *Redis) restoreBatch(ctx context.Context, b []message.Payload) error {
+	// Detach from ctx, so that cancellation can't interrupt the pipeline.
+	bctx := context.Background()
+	// Commands like INCR or RPUSH aren't idempotent: a batch of them may
+	// have been applied before a connection error, it isn't retried.
+	// Batches holding transactions, never split by collect, are applied
+	// as a single transaction.
+	exec := r.exec
+	for _, p := range b {
+		if multi, _ := transaction(p); multi {
+			exec = r.execTx
+			break
+		}
+		if len(p.Command) > 0 {
+			exec = r.execOnce
+		}
+	}
+	cmds := make([]redis.Cmder, len(b))
+	err := exec(bctx, func(pipe redis.Pipeliner) {
+		for i, p := range b {
+			cmds[i] = r.restore(bctx, pipe, p)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(r.Log, "\nbatch of %d keys with error %s, keys may be partially restored\n", len(b), err)
+		return err
+	}
+
+	var confirmed []string
+	for i, p := range b {
+		// Keys failing to restore are still in the source, never mirrored.
+		if r.Seen != nil {
+			r.Seen.Add(p.Key)
+		}
+		err := cmds[i].Err()
+		if versionMismatch(err) {
+			r.fallback.Do(func() {
+				fmt.Fprintln(r.Log, "\nredis write: DUMP payloads rejected, restoring them with native commands")
+			})
+			err = r.restoreLogical(bctx, p)
+		}
+		if err != nil {
+			fmt.Fprintf(r.Log, "\nkey %s with error %s\n", p.Key, err)
+			continue
+		}
+		confirmed = append(confirmed, p.Key)
+		r.maybeLog("w")
+	}
+	r.Checkpoint.Confirm(confirmed...)
+	failed := len(b) - len(confirmed)
+
+	if ctx.Err() != nil {
+		fmt.Fprintf(r.Log, "\nredis write: completed batch of %d keys before exit\n", len(b))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d keys failed to restore", failed)
 	}
 
 	return nil
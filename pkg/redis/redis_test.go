@@ -1,14 +1,24 @@
 package redis_test
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/domwong/rump/pkg/filter"
+	"github.com/domwong/rump/pkg/keyset"
 	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
 	"github.com/domwong/rump/pkg/redis"
 	rredis "github.com/go-redis/redis/v8"
 )
@@ -103,7 +113,7 @@ func TestReadWriteTTL(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error reading %s", err)
 		}
-		if db2.PTTL(ctx, k).Val() == 0 {
+		if db2.PTTL(ctx, k).Val() <= 0 {
 			t.Errorf("ttl non transferred")
 		}
 	}
@@ -113,3 +123,696 @@ func TestReadWriteTTL(t *testing.T) {
 		t.Errorf("expected: %v, result: %v", expected, result)
 	}
 }
+
+// Test db1 to db2 sync with absolute expire times
+func TestReadWriteAbsTTL(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, false, true)
+	target := redis.New(db2, ch, false, true)
+	target.AbsTTL = true
+	ctx := context.Background()
+
+	// Read all keys from db1, push to shared message bus
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Write all keys from message bus to db2
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	for k := range expected {
+		ttl := db2.PTTL(ctx, k).Val()
+		if ttl <= 0 || ttl > 30*time.Second {
+			t.Errorf("expected ttl in (0, 30s] for %s, got %s", k, ttl)
+		}
+	}
+}
+
+// Test reading db1 keys selected by pattern and type
+func TestReadFilter(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, false, false)
+	source.Filter = filter.Filter{
+		Include: []string{"key1*"},
+		Exclude: []string{"key10"},
+		Types:   []string{"string"},
+	}
+	ctx := context.Background()
+
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]bool{}
+	for p := range ch {
+		result[p.Key] = true
+	}
+
+	// key1 and key11 to key19
+	if len(result) != 10 || !result["key1"] || result["key10"] || result["key2"] {
+		t.Errorf("unexpected filtered keys: %v", result)
+	}
+}
+
+// Test db1 to db2 sync with small pipelines, several in flight,
+// and several writers
+func TestReadWritePipelines(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, false, true)
+	source.Batch = 3
+	source.Depth = 2
+	target := redis.New(db2, ch, false, true)
+	target.Writers = 3
+	target.WriteBatch = 2
+	ctx := context.Background()
+
+	// Read all keys from db1, push to shared message bus
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Write all keys from message bus to db2
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Get all db2 keys
+	result := map[string]string{}
+	for k := range expected {
+		result[k] = db2.Get(ctx, k).Val()
+	}
+
+	// Compare db1 keys with db2 keys
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// failoverClient returns a first page of count keys, fails the second SCAN
+// with a connection error, then reports the run ID of a new master, like
+// a Sentinel failover.
+type failoverClient struct {
+	rredis.UniversalClient
+	scans   int
+	cursors []uint64
+}
+
+func (c *failoverClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *rredis.ScanCmd {
+	c.scans++
+	c.cursors = append(c.cursors, cursor)
+	switch c.scans {
+	case 1:
+		keys, _, err := c.UniversalClient.Scan(ctx, cursor, match, count).Result()
+		if len(keys) > int(count) {
+			keys = keys[:count]
+		}
+		return rredis.NewScanCmdResult(keys, 7, err)
+	case 2:
+		return rredis.NewScanCmdResult(nil, 0, io.ErrUnexpectedEOF)
+	}
+	return c.UniversalClient.Scan(ctx, cursor, match, count)
+}
+
+func (c *failoverClient) Info(ctx context.Context, section ...string) *rredis.StringCmd {
+	if c.scans < 2 {
+		return rredis.NewStringResult("# Server\r\nrun_id:old\r\n", nil)
+	}
+	return rredis.NewStringResult("# Server\r\nrun_id:new\r\n", nil)
+}
+
+// Test SCAN restarting from cursor 0 on a new master
+func TestReadFailover(t *testing.T) {
+	c := &failoverClient{UniversalClient: db1}
+	bus := make(message.Bus, 100)
+	source := redis.New(c, bus, true, false)
+	source.Batch = 5
+	source.FailoverTimeout = 5 * time.Second
+
+	if err := source.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	result := map[string]bool{}
+	for p := range bus {
+		result[p.Key] = true
+	}
+	if len(result) != len(expected) || !reflect.DeepEqual(c.cursors, []uint64{0, 7, 0}) {
+		t.Errorf("expected %d keys, result %d, cursors %v", len(expected), len(result), c.cursors)
+	}
+}
+
+// failingClient fails the DUMP of a key.
+type failingClient struct {
+	rredis.UniversalClient
+	key string
+}
+
+func (c *failingClient) Pipeline() rredis.Pipeliner {
+	return &failingPipeline{c.UniversalClient.Pipeline(), c.key}
+}
+
+type failingPipeline struct {
+	rredis.Pipeliner
+	key string
+}
+
+func (p *failingPipeline) Dump(ctx context.Context, key string) *rredis.StringCmd {
+	if key == p.key {
+		return rredis.NewStringResult("", io.ErrUnexpectedEOF)
+	}
+	return p.Pipeliner.Dump(ctx, key)
+}
+
+// Test a key failing to be read failing the read, once the others are read
+func TestReadKeyError(t *testing.T) {
+	bus := make(message.Bus, 100)
+	source := redis.New(&failingClient{db1, "key1"}, bus, true, false)
+
+	if err := source.Read(context.Background()); err == nil {
+		t.Error("read should fail")
+	}
+
+	n := 0
+	for range bus {
+		n++
+	}
+	if n != len(expected)-1 {
+		t.Errorf("expected %d keys, result %d", len(expected)-1, n)
+	}
+}
+
+// droppingClient runs pipelines, then fails them with a connection error,
+// like a connection dropped before the replies.
+type droppingClient struct {
+	rredis.UniversalClient
+	execs int
+}
+
+func (c *droppingClient) Pipeline() rredis.Pipeliner {
+	return &droppingPipeline{c.UniversalClient.Pipeline(), c}
+}
+
+type droppingPipeline struct {
+	rredis.Pipeliner
+	c *droppingClient
+}
+
+func (p *droppingPipeline) Exec(ctx context.Context) ([]rredis.Cmder, error) {
+	p.c.execs++
+	cmds, _ := p.Pipeliner.Exec(ctx)
+	return cmds, io.ErrUnexpectedEOF
+}
+
+// Test a batch of commands isn't retried, it may have been applied
+func TestWriteCommandsNotRetried(t *testing.T) {
+	ctx := context.Background()
+	db2.Del(ctx, "retried")
+	defer db2.Del(ctx, "retried")
+
+	bus := make(message.Bus, 1)
+	bus <- message.Payload{Key: "retried", Command: []string{"RPUSH", "retried", "a"}}
+	close(bus)
+
+	c := &droppingClient{UniversalClient: db2}
+	target := redis.New(c, bus, true, false)
+	target.FailoverTimeout = 5 * time.Second
+	if err := target.Write(ctx); err == nil {
+		t.Error("write should fail")
+	}
+	if n := db2.LLen(ctx, "retried").Val(); c.execs != 1 || n != 1 {
+		t.Errorf("expected a single exec, got %d execs and %d elements", c.execs, n)
+	}
+}
+
+// Test verifying db2 against db1, before and after altering db2
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	sync := func(verify bool, report string) error {
+		ch = make(message.Bus, 100)
+		source := redis.New(db1, ch, false, true)
+		target := redis.New(db2, ch, false, true)
+		target.VerifyReport = report
+
+		if err := source.Read(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+		if verify {
+			return target.Verify(ctx)
+		}
+		return target.Write(ctx)
+	}
+
+	if err := sync(false, ""); err != nil {
+		t.Error("error: ", err)
+	}
+	if err := sync(true, ""); err != nil {
+		t.Error("error: ", err)
+	}
+
+	db2.Set(ctx, "key1", "changed", 30*time.Second)
+	db2.Del(ctx, "key2")
+	db2.Persist(ctx, "key3")
+	db2.Set(ctx, "extra", "value", 0)
+	defer db2.Del(ctx, "extra")
+
+	report := os.TempDir() + "/verify.json"
+	defer os.Remove(report)
+	if err := sync(true, report); err == nil {
+		t.Error("expected differences")
+	}
+
+	d, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(d)), "\n") {
+		var diff redis.Difference
+		if err := json.Unmarshal([]byte(line), &diff); err != nil {
+			t.Fatal(err)
+		}
+		result[diff.Key] = diff.Kind
+	}
+
+	expected := map[string]string{
+		"key1":  redis.KindValue,
+		"key2":  redis.KindMissing,
+		"key3":  redis.KindTTL,
+		"extra": redis.KindExtra,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test verifying selected keys only, their values compared decoded when
+// encoded differently
+func TestVerifyFilter(t *testing.T) {
+	ctx := context.Background()
+	db2.Set(ctx, "int", "123", 0)
+	db2.Set(ctx, "extra", "value", 0)
+	defer db2.Del(ctx, "int", "extra")
+
+	bus := make(message.Bus, 1)
+	bus <- message.Payload{Key: "int", Value: rdb.Dump(rdb.TypeString, []byte("\xc0\x7b"), 9), Ttl: "0"}
+	close(bus)
+
+	target := redis.New(db2, bus, true, false)
+	target.Filter = filter.Filter{Include: []string{"int"}}
+	if err := target.Verify(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+}
+
+// Test mirroring db1 to db2, deleting db2 keys absent from db1
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, false, false)
+	target := redis.New(db2, ch, false, false)
+	target.Seen = keyset.New()
+
+	db2.Set(ctx, "extra1", "value", 0)
+	db2.Set(ctx, "extra2", "value", 0)
+	defer db2.Del(ctx, "extra1", "extra2")
+
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	target.MirrorDryRun = true
+	if err := target.Mirror(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if db2.Exists(ctx, "extra1", "extra2").Val() != 2 {
+		t.Error("dry run should not delete keys")
+	}
+
+	target.MirrorDryRun = false
+	target.MirrorLimit = 1
+	if err := target.Mirror(ctx); err == nil {
+		t.Error("expected the limit to abort mirror")
+	}
+	if db2.Exists(ctx, "extra1", "extra2").Val() != 2 {
+		t.Error("aborted mirror should not delete keys")
+	}
+
+	target.MirrorLimit = 2
+	if err := target.Mirror(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if db2.Exists(ctx, "extra1", "extra2").Val() != 0 {
+		t.Error("mirror should delete extra keys")
+	}
+	if n := db2.DBSize(ctx).Val(); n != int64(len(expected)) {
+		t.Errorf("expected %d keys, got %d", len(expected), n)
+	}
+
+	// Keys not selected by the Filter aren't deleted.
+	db2.Set(ctx, "other", "value", 0)
+	defer db2.Del(ctx, "other")
+	filtered := redis.New(db2, nil, true, false)
+	filtered.Filter = filter.Filter{Include: []string{"key1*"}}
+	filtered.Seen = keyset.New()
+	filtered.Seen.Add("key1")
+	if err := filtered.Mirror(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if db2.Exists(ctx, "other", "key2", "key1").Val() != 3 || db2.Exists(ctx, "key10").Val() != 0 {
+		t.Error("mirror should only delete the selected keys")
+	}
+}
+
+// wait returns whether cond became true within a few seconds.
+func wait(cond func() bool) bool {
+	for i := 0; i < 50; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+// Test following db1 changes after the initial sync to db2
+func TestFollow(t *testing.T) {
+	db2.FlushDB(context.Background())
+	events := db1.ConfigGet(context.Background(), "notify-keyspace-events").Val()
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, false, true)
+	source.Follow = true
+	target := redis.New(db2, ch, false, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := source.Read(ctx); err != context.Canceled {
+			t.Error("expected context canceled, got: ", err)
+		}
+	}()
+	go target.Write(ctx)
+
+	bg := context.Background()
+	if !wait(func() bool { return db2.Exists(bg, "key20").Val() == 1 }) {
+		t.Fatal("initial sync not done")
+	}
+
+	db1.Set(bg, "followed", "value", 0)
+	db1.Del(bg, "key2")
+	defer db1.Set(bg, "key2", "value2", 30*time.Second)
+	defer db1.Del(bg, "followed")
+
+	if !wait(func() bool { return db2.Get(bg, "followed").Val() == "value" }) {
+		t.Error("changed key not synced")
+	}
+	if !wait(func() bool { return db2.Exists(bg, "key2").Val() == 0 }) {
+		t.Error("deleted key not synced")
+	}
+
+	cancel()
+	<-done
+	if restored := db1.ConfigGet(bg, "notify-keyspace-events").Val(); !reflect.DeepEqual(restored, events) {
+		t.Errorf("expected notify-keyspace-events %v, got %v", events, restored)
+	}
+}
+
+// Test db1 to db2 sync reading values with type-specific commands
+func TestLogical(t *testing.T) {
+	bg := context.Background()
+	db2.FlushDB(bg)
+	db1.RPush(bg, "list", "a", "b")
+	db1.SAdd(bg, "set", "x")
+	db1.HSet(bg, "hash", "f", "v")
+	db1.ZAdd(bg, "zset", &rredis.Z{Member: "m", Score: 1.5})
+	db1.XAdd(bg, &rredis.XAddArgs{Stream: "stream", ID: "5-1", Values: []string{"f", "v"}})
+	defer db1.Del(bg, "list", "set", "hash", "zset", "stream")
+
+	ch = make(message.Bus, 1000)
+	source := redis.New(db1, ch, false, true)
+	source.Logical = true
+	target := redis.New(db2, ch, false, true)
+
+	if err := source.Read(bg); err != nil {
+		t.Error("error: ", err)
+	}
+	if err := target.Write(bg); err != nil {
+		t.Error("error: ", err)
+	}
+
+	if v := db2.Get(bg, "key1").Val(); v != "value1" {
+		t.Errorf("unexpected string %s", v)
+	}
+	if ttl := db2.PTTL(bg, "key1").Val(); ttl <= 0 || ttl > 30*time.Second {
+		t.Errorf("unexpected ttl %s", ttl)
+	}
+	if l := db2.LRange(bg, "list", 0, -1).Val(); !reflect.DeepEqual(l, []string{"a", "b"}) {
+		t.Errorf("unexpected list %v", l)
+	}
+	if s := db2.SMembers(bg, "set").Val(); !reflect.DeepEqual(s, []string{"x"}) {
+		t.Errorf("unexpected set %v", s)
+	}
+	if h := db2.HGetAll(bg, "hash").Val(); !reflect.DeepEqual(h, map[string]string{"f": "v"}) {
+		t.Errorf("unexpected hash %v", h)
+	}
+	if s := db2.ZScore(bg, "zset", "m").Val(); s != 1.5 {
+		t.Errorf("unexpected score %v", s)
+	}
+	if x := db2.XRange(bg, "stream", "-", "+").Val(); len(x) != 1 || x[0].ID != "5-1" || x[0].Values["f"] != "v" {
+		t.Errorf("unexpected stream %v", x)
+	}
+}
+
+// Test logical copies rebuilding each key in a transaction, never split
+// across batches nor partially applied, with absolute expire times
+func TestLogicalTransaction(t *testing.T) {
+	bg := context.Background()
+	db1.RPush(bg, "txlist", "a", "b")
+	db1.PExpire(bg, "txlist", time.Hour)
+	defer db1.Del(bg, "txlist")
+	defer db2.Del(bg, "txlist", "interrupted")
+
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, false, true)
+	source.Logical = true
+	source.AbsTTL = true
+	source.Filter = filter.Filter{Include: []string{"txlist"}}
+	if err := source.Read(bg); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var cmds []string
+	var payloads []message.Payload
+	for p := range ch {
+		cmds = append(cmds, p.Command[0])
+		payloads = append(payloads, p)
+	}
+	if !reflect.DeepEqual(cmds, []string{"MULTI", "DEL", "RPUSH", "PEXPIREAT", "EXEC"}) {
+		t.Fatalf("unexpected commands %q", cmds)
+	}
+
+	ch = make(message.Bus, 100)
+	for _, p := range payloads {
+		ch <- p
+	}
+	close(ch)
+	target := redis.New(db2, ch, false, true)
+	target.WriteBatch = 1
+	if err := target.Write(bg); err != nil {
+		t.Error("error: ", err)
+	}
+	if l := db2.LRange(bg, "txlist", 0, -1).Val(); !reflect.DeepEqual(l, []string{"a", "b"}) {
+		t.Errorf("unexpected list %v", l)
+	}
+	if ttl := db2.PTTL(bg, "txlist").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("unexpected ttl %s", ttl)
+	}
+
+	// A transaction without its EXEC leaves its key unchanged.
+	db2.Set(bg, "interrupted", "v", 0)
+	ch = make(message.Bus, 100)
+	ch <- message.Payload{Key: "interrupted", Command: []string{"MULTI"}}
+	ch <- message.Payload{Key: "interrupted", Command: []string{"DEL", "interrupted"}}
+	ch <- message.Payload{Key: "interrupted", Command: []string{"RPUSH", "interrupted", "a"}}
+	close(ch)
+	target = redis.New(db2, ch, false, true)
+	if err := target.Write(bg); err == nil {
+		t.Error("expected interrupted transaction error")
+	}
+	if v := db2.Get(bg, "interrupted").Val(); v != "v" {
+		t.Errorf("unexpected value %q", v)
+	}
+}
+
+// Test restoring with native commands DUMP payloads of a newer RDB version
+func TestRestoreFallback(t *testing.T) {
+	bg := context.Background()
+	ch = make(message.Bus, 10)
+	ch <- message.Payload{Key: "newer", Value: rdb.Dump(rdb.TypeHash, []byte("\x01\x01f\x01v"), 10), Ttl: "60000"}
+	close(ch)
+	defer db2.Del(bg, "newer")
+
+	target := redis.New(db2, ch, false, true)
+	if err := target.Write(bg); err != nil {
+		t.Error("error: ", err)
+	}
+
+	if h := db2.HGetAll(bg, "newer").Val(); !reflect.DeepEqual(h, map[string]string{"f": "v"}) {
+		t.Errorf("unexpected hash %v", h)
+	}
+	if ttl := db2.PTTL(bg, "newer").Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("unexpected ttl %s", ttl)
+	}
+}
+
+// Test reading as a replica from a fake master
+func TestReplica(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	mark := strings.Repeat("m", 40)
+	stream := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*3\r\n$3\r\nSET\r\n$4\r\nkey2\r\n$2\r\nv2\r\n" +
+		"*1\r\n$4\r\nPING\r\n" +
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n" +
+		"*3\r\n$3\r\nSET\r\n$5\r\nother\r\n$1\r\nv\r\n" +
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*2\r\n$3\r\nDEL\r\n$4\r\nkey1\r\n"
+	acked := make(chan string, 100)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		for {
+			args, err := readCommand(br)
+			if err != nil {
+				return
+			}
+
+			switch strings.ToLower(strings.Join(args[:2], " ")) {
+			case "replconf ack":
+				acked <- args[2]
+			case "psync ?":
+				io.WriteString(conn, "+FULLRESYNC 0123456789 100\r\n\n$EOF:"+mark+"\r\n")
+				io.WriteString(conn, "REDIS0009\xfe\x00\x00\x04key1\x06value1\xff\x00\x00\x00\x00\x00\x00\x00\x00")
+				io.WriteString(conn, mark+stream)
+			default:
+				io.WriteString(conn, "+OK\r\n")
+			}
+		}
+	}()
+
+	ch = make(message.Bus, 100)
+	source := redis.NewReplica(&rredis.Options{Addr: l.Addr().String()}, ch, false, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- source.Read(ctx)
+	}()
+
+	var result []message.Payload
+	for p := range ch {
+		result = append(result, p)
+		if len(result) == 3 {
+			break
+		}
+	}
+
+	expected := []message.Payload{
+		{Key: "key1", Value: rdb.Dump(rdb.TypeString, []byte("\x06value1"), 9), Ttl: "0"},
+		{Key: "key2", Command: []string{"SET", "key2", "v2"}},
+		{Key: "key1", Command: []string{"DEL", "key1"}},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+
+	// The offset acknowledged covers the whole stream.
+	end := strconv.Itoa(100 + len(stream))
+	timeout := time.After(5 * time.Second)
+	for offset := ""; offset != end; {
+		select {
+		case offset = <-acked:
+		case <-timeout:
+			t.Fatalf("expected ack of offset %s, got %s", end, offset)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("expected context canceled, got: ", err)
+	}
+}
+
+// Test db1 to db2 sync as a replica, if the local Redis allows PSYNC
+func TestReplicaRedis(t *testing.T) {
+	db2.FlushDB(context.Background())
+	ch = make(message.Bus, 100)
+	source := redis.NewReplica(db1.Options(), ch, false, true)
+	target := redis.New(db2, ch, false, true)
+	target.Writers = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- source.Read(ctx)
+	}()
+	go target.Write(ctx)
+
+	bg := context.Background()
+	synced := wait(func() bool {
+		select {
+		case err := <-readErr:
+			t.Skip("replication unavailable: ", err)
+		default:
+		}
+		return db2.Exists(bg, "key20").Val() == 1
+	})
+	if !synced {
+		t.Fatal("snapshot not synced")
+	}
+
+	db1.Set(bg, "replicated", "value", 0)
+	defer db1.Del(bg, "replicated")
+	if !wait(func() bool { return db2.Get(bg, "replicated").Val() == "value" }) {
+		t.Error("replicated command not synced")
+	}
+}
+
+// readCommand reads a RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+	args := make([]string, count)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSpace(arg)
+	}
+
+	return args, nil
+}
@@ -0,0 +1,345 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+	"github.com/domwong/rump/pkg/resp"
+	"github.com/go-redis/redis/v8"
+)
+
+// ackInterval is the interval between replication offset acknowledgements,
+// without which the master drops the replica after its repl-timeout.
+const ackInterval = time.Second
+
+// Replica reads a Redis DB as a replica, with the PSYNC replication protocol.
+// The RDB snapshot sent by the master is pushed as Payloads, then the
+// commands of the replication stream as command Payloads, until the
+// context is done.
+// Options are the master address, credentials and DB.
+// Silent disables verbose mode.
+// TTL enables TTL sync.
+// Log is where progress and status messages are printed, os.Stdout by
+// default.
+// Keys can't be filtered: replicated commands may name several keys,
+// of any type.
+type Replica struct {
+	Options *redis.Options
+	Bus     message.Bus
+	Silent  bool
+	TTL     bool
+	Log     io.Writer
+
+	conn   net.Conn
+	r      *bufio.Reader
+	mu     sync.Mutex
+	offset int64
+}
+
+// NewReplica creates the Replica struct, used to read.
+func NewReplica(opts *redis.Options, bus message.Bus, silent, ttl bool) *Replica {
+	return &Replica{
+		Options: opts,
+		Bus:     bus,
+		Silent:  silent,
+		TTL:     ttl,
+		Log:     os.Stdout,
+	}
+}
+
+// maybeLog may log, depending on the Silent flag
+func (r *Replica) maybeLog(s string) {
+	if r.Silent {
+		return
+	}
+	fmt.Fprint(r.Log, s)
+}
+
+// Read performs a full resynchronization from the master, pushing
+// the snapshot keys then the replicated commands to the message Bus.
+// To be used in an ErrGroup.
+func (r *Replica) Read(ctx context.Context) error {
+	defer close(r.Bus)
+
+	if err := r.connect(ctx); err != nil {
+		return err
+	}
+	defer r.conn.Close()
+
+	// Interrupt blocking reads once ctx is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.conn.Close()
+		case <-stop:
+		}
+	}()
+
+	err := r.sync(ctx)
+	if ctx.Err() != nil {
+		fmt.Fprintln(r.Log)
+		fmt.Fprintln(r.Log, "redis replica: exit")
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// sync runs the PSYNC handshake, reads the snapshot and the stream.
+func (r *Replica) sync(ctx context.Context) error {
+	if r.Options.Password != "" {
+		args := []string{"auth", r.Options.Password}
+		if r.Options.Username != "" {
+			args = []string{"auth", r.Options.Username, r.Options.Password}
+		}
+		if _, err := r.call(args...); err != nil {
+			return err
+		}
+	}
+
+	_, port, _ := net.SplitHostPort(r.conn.LocalAddr().String())
+	if _, err := r.call("replconf", "listening-port", port); err != nil {
+		return err
+	}
+	// Announce diskless transfers support, the RDB is then EOF delimited.
+	if _, err := r.call("replconf", "capa", "eof", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	reply, err := r.call("psync", "?", "-1")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply)
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return fmt.Errorf("redis replica: unexpected psync reply %s", reply)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("redis replica: unexpected psync reply %s", reply)
+	}
+
+	if err := r.snapshot(ctx); err != nil {
+		return err
+	}
+
+	r.offset = offset
+	return r.stream(ctx)
+}
+
+// connect opens the connection to the master.
+func (r *Replica) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.Options.Addr)
+	if err != nil {
+		return err
+	}
+	if r.Options.TLSConfig != nil {
+		conn = tls.Client(conn, r.Options.TLSConfig)
+	}
+
+	r.conn = conn
+	r.r = bufio.NewReader(conn)
+	return nil
+}
+
+// send sends a command to the master.
+func (r *Replica) send(args ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.conn.Write(resp.Command(args...))
+	return err
+}
+
+// call sends a command to the master and reads its status reply.
+func (r *Replica) call(args ...string) (string, error) {
+	if err := r.send(args...); err != nil {
+		return "", err
+	}
+
+	line, err := resp.ReadLine(r.r)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(line, "-"):
+		return "", fmt.Errorf("redis replica: %s %s", args[0], line[1:])
+	case !strings.HasPrefix(line, "+"):
+		return "", fmt.Errorf("redis replica: unexpected %s reply %q", args[0], line)
+	}
+
+	return line[1:], nil
+}
+
+// snapshot reads the RDB snapshot and pushes its keys to the message Bus.
+// The snapshot is either sized, or delimited by an EOF mark.
+func (r *Replica) snapshot(ctx context.Context) error {
+	// The master sends newlines while preparing the snapshot.
+	var line string
+	for line == "" {
+		var err error
+		if line, err = resp.ReadLine(r.r); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(line, "$") {
+		return fmt.Errorf("redis replica: unexpected snapshot %q", line)
+	}
+
+	var src *bufio.Reader
+	mark := strings.TrimPrefix(line, "$EOF:")
+	if mark == line {
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("redis replica: unexpected snapshot %q", line)
+		}
+		src = bufio.NewReader(io.LimitReader(r.r, size))
+		mark = ""
+	} else {
+		src = r.r
+	}
+
+	rdr, err := rdb.NewReader(src)
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := rdr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.push(ctx, e); err != nil {
+			return err
+		}
+	}
+
+	if mark != "" {
+		end := make([]byte, len(mark))
+		if _, err := io.ReadFull(r.r, end); err != nil {
+			return err
+		}
+		if string(end) != mark {
+			return fmt.Errorf("redis replica: snapshot EOF mark mismatch")
+		}
+	}
+
+	return nil
+}
+
+// push pushes a snapshot key of the DB to the message Bus.
+func (r *Replica) push(ctx context.Context, e *rdb.Entry) error {
+	if e.DB != r.Options.DB {
+		return nil
+	}
+
+	p := message.Payload{Key: e.Key, Value: e.Dump, Ttl: "0"}
+	if r.TTL && e.ExpireAt > 0 {
+		ttl := e.ExpireAt - time.Now().UnixNano()/int64(time.Millisecond)
+		// Expired keys are deleted by the replication stream.
+		if ttl <= 0 {
+			return nil
+		}
+		p.Ttl = strconv.FormatInt(ttl, 10)
+		p.ExpireAt = e.ExpireAt
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r.Bus <- p:
+		r.maybeLog("r")
+	}
+
+	return nil
+}
+
+// stream reads the replication stream and pushes the commands
+// of the DB to the message Bus, acknowledging the processed offset.
+func (r *Replica) stream(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		t := time.NewTicker(ackInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				r.ack()
+			}
+		}
+	}()
+
+	db := 0
+	for {
+		args, n, err := resp.ReadCommand(r.r)
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.offset += n
+		r.mu.Unlock()
+
+		switch strings.ToLower(args[0]) {
+		case "ping", "multi", "exec":
+			continue
+		case "replconf":
+			if len(args) > 1 && strings.ToLower(args[1]) == "getack" {
+				r.ack()
+			}
+			continue
+		case "select":
+			if len(args) > 1 {
+				db, _ = strconv.Atoi(args[1])
+			}
+			continue
+		case "flushall":
+			// Only the DB is synced.
+			args = []string{"flushdb"}
+		}
+
+		if db != r.Options.DB {
+			continue
+		}
+
+		p := message.Payload{Command: args}
+		if len(args) > 1 {
+			p.Key = args[1]
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r.Bus <- p:
+			r.maybeLog("r")
+		}
+	}
+}
+
+// ack acknowledges the processed replication offset.
+func (r *Replica) ack() {
+	r.mu.Lock()
+	offset := r.offset
+	r.mu.Unlock()
+
+	// Errors surface on the next read.
+	r.send("replconf", "ack", strconv.FormatInt(offset, 10))
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// retryInterval is the pause between two attempts while waiting for a failover.
+const retryInterval = 500 * time.Millisecond
+
+// retryable reports whether err may go away once a new master is elected:
+// network errors, and replies from a demoted or still loading node.
+func retryable(err error) bool {
+	switch err {
+	case nil, redis.Nil, context.Canceled, context.DeadlineExceeded:
+		return false
+	}
+
+	if _, ok := err.(redis.Error); ok {
+		s := err.Error()
+		return strings.HasPrefix(s, "READONLY ") ||
+			strings.HasPrefix(s, "LOADING ") ||
+			strings.HasPrefix(s, "MASTERDOWN ") ||
+			strings.HasPrefix(s, "TRYAGAIN ")
+	}
+
+	return true
+}
+
+// retry runs fn until it succeeds with a non retryable result,
+// ctx is done or FailoverTimeout elapses.
+// Without FailoverTimeout fn is run only once.
+func (r *Redis) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+	if r.FailoverTimeout <= 0 || !retryable(err) {
+		return err
+	}
+
+	fmt.Fprintf(r.Log, "\nredis: %s, waiting for failover\n", err)
+	deadline := time.Now().Add(r.FailoverTimeout)
+	for retryable(err) && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+		err = fn()
+	}
+
+	return err
+}
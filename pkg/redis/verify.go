@@ -0,0 +1,273 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/domwong/rump/pkg/keyset"
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+	"github.com/go-redis/redis/v8"
+)
+
+// Kinds of Differences found by Verify.
+const (
+	KindMissing = "missing"
+	KindExtra   = "extra"
+	KindValue   = "value"
+	KindTTL     = "ttl"
+)
+
+// Difference is a key differing between the source and the target.
+// Kind is one of KindMissing, KindExtra, KindValue or KindTTL.
+type Difference struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+}
+
+// verification collects the results of Verify.
+type verification struct {
+	mu      sync.Mutex
+	seen    *keyset.Set
+	report  *json.Encoder
+	checked int
+	counts  map[string]int
+}
+
+// add records a Difference, writing it to the report if any.
+func (v *verification) add(d Difference) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.counts[d.Kind]++
+	if v.report == nil {
+		return nil
+	}
+	return v.report.Encode(d)
+}
+
+// differences returns the number of Differences found.
+func (v *verification) differences() int {
+	var n int
+	for _, c := range v.counts {
+		n += c
+	}
+	return n
+}
+
+// Verify compares the keys on the message bus with the db, instead of
+// restoring them. Values are compared by DUMP payload, ignoring the RDB
+// version, or decoded when their encodings differ, and with TTL sync
+// expire times within TTLWindow.
+// Once the bus is closed, the db is scanned for keys selected by the
+// Filter but absent from the bus.
+// Differences are written as JSON lines to VerifyReport, if set,
+// and an error is returned if any.
+func (r *Redis) Verify(ctx context.Context) error {
+	v := &verification{
+		seen:   keyset.New(),
+		counts: map[string]int{},
+	}
+
+	if r.VerifyReport != "" {
+		f, err := os.Create(r.VerifyReport)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+		v.report = json.NewEncoder(w)
+	}
+
+	err := r.drain(ctx, func(ctx context.Context, b []message.Payload) error {
+		return r.verifyBatch(ctx, v, b)
+	})
+	if err == nil {
+		err = r.walk(ctx, func(keys []string) error {
+			for _, key := range keys {
+				if v.seen.Has(key) {
+					continue
+				}
+				if err := v.add(Difference{Key: key, Kind: KindExtra}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(r.Log)
+			fmt.Fprintln(r.Log, "redis verify: exit")
+		}
+		return err
+	}
+
+	fmt.Fprintln(r.Log)
+	fmt.Fprintf(r.Log, "verify: checked %d keys, %d missing, %d extra, %d different values, %d different ttls\n",
+		v.checked, v.counts[KindMissing], v.counts[KindExtra], v.counts[KindValue], v.counts[KindTTL])
+
+	if n := v.differences(); n > 0 {
+		return fmt.Errorf("verify: %d keys differ", n)
+	}
+
+	return nil
+}
+
+// verifyBatch compares a batch of Payloads with the db, using a single
+// pipeline of DUMP and PTTL.
+func (r *Redis) verifyBatch(ctx context.Context, v *verification, b []message.Payload) error {
+	dumps := make([]*redis.StringCmd, len(b))
+	ttls := make([]*redis.DurationCmd, len(b))
+	err := r.exec(ctx, func(pipe redis.Pipeliner) {
+		for i, p := range b {
+			dumps[i] = pipe.Dump(ctx, p.Key)
+			if r.TTL {
+				ttls[i] = pipe.PTTL(ctx, p.Key)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	for i, p := range b {
+		v.seen.Add(p.Key)
+
+		value, err := dumps[i].Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("key %s with error %s", p.Key, err)
+		}
+
+		var kind string
+		switch {
+		case err == redis.Nil:
+			kind = KindMissing
+		case !sameValue(value, p.Value):
+			kind = KindValue
+		case r.TTL && !r.sameTTL(p, ttls[i].Val(), now):
+			kind = KindTTL
+		}
+
+		v.mu.Lock()
+		v.checked++
+		v.mu.Unlock()
+
+		if kind == "" {
+			r.maybeLog("v")
+			continue
+		}
+		if err := v.add(Difference{Key: p.Key, Kind: kind}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sameValue reports whether two DUMP payloads hold the same value: the
+// same bytes, or the same decoded value, as encodings and the order of
+// hashtable elements may differ between the source and the target.
+func sameValue(a, b string) bool {
+	if rdb.DumpBody(a) == rdb.DumpBody(b) {
+		return true
+	}
+
+	va, err := rdb.Decode(a)
+	if err != nil {
+		return false
+	}
+	vb, err := rdb.Decode(b)
+	if err != nil {
+		return false
+	}
+	if va.Type == "set" {
+		sort.Strings(va.Elements)
+		sort.Strings(vb.Elements)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// sameTTL reports whether the db key PTTL, read at now, matches
+// the Payload expire time within TTLWindow.
+func (r *Redis) sameTTL(p message.Payload, pttl time.Duration, now time.Time) bool {
+	ttl, _ := strconv.ParseInt(p.Ttl, 10, 64)
+	if ttl <= 0 {
+		return pttl < 0
+	}
+	if pttl < 0 {
+		return false
+	}
+
+	expireAt := time.Unix(0, p.ExpireAt*int64(time.Millisecond))
+	if p.ExpireAt == 0 {
+		expireAt = now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+
+	diff := now.Add(pttl).Sub(expireAt)
+	return -r.TTLWindow <= diff && diff <= r.TTLWindow
+}
+
+// walk scans the whole db for keys selected by the Filter,
+// handing pages of keys to fn.
+// With a Redis Cluster every master is scanned in parallel,
+// so fn must be safe for concurrent use.
+func (r *Redis) walk(ctx context.Context, fn func(keys []string) error) error {
+	if c, ok := r.client.(*redis.ClusterClient); ok {
+		return c.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return r.walkNode(ctx, node, fn)
+		})
+	}
+
+	return r.walkNode(ctx, r.client, fn)
+}
+
+// walkNode scans a single node for keys selected by the Filter.
+func (r *Redis) walkNode(ctx context.Context, node redis.Cmdable, fn func(keys []string) error) error {
+	match := r.Filter.ScanMatch()
+	scanType := r.Filter.ScanType()
+
+	var cursor uint64
+	var master string
+	for {
+		keys, next, _, err := r.scanPage(ctx, node, cursor, match, &scanType, &master)
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		var matches []string
+		for _, key := range keys {
+			if r.Filter.MatchKey(key) {
+				matches = append(matches, key)
+			}
+		}
+		if scanType == "" && len(r.Filter.Types) > 0 && len(matches) > 0 {
+			matches, err = r.matchTypes(ctx, nil, matches)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(matches) > 0 {
+			if err := fn(matches); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
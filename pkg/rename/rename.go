@@ -0,0 +1,196 @@
+// Package rename rewrites the keys on their way from the source to the
+// target, following Rules, and detects the collisions of source keys
+// renamed to the same target key.
+package rename
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/domwong/rump/pkg/keyset"
+	"github.com/domwong/rump/pkg/message"
+)
+
+// Kinds of Rules.
+const (
+	// StripPrefix removes a prefix from the keys starting with it.
+	StripPrefix = "strip-prefix"
+	// AddPrefix adds a prefix to all keys.
+	AddPrefix = "add-prefix"
+	// Regex replaces the matches of a regular expression with a
+	// replacement, expanding capture groups like $1 or ${name}.
+	Regex = "regex"
+)
+
+// regexSeparator separates the regular expression from its replacement.
+const regexSeparator = "=>"
+
+// Rule rewrites keys.
+// Prefix is the prefix of StripPrefix and AddPrefix Rules, Regexp and
+// Replacement the regular expression and replacement of Regex Rules.
+type Rule struct {
+	Kind        string
+	Prefix      string
+	Regexp      *regexp.Regexp
+	Replacement string
+}
+
+// Parse parses a Rule: strip-prefix=PREFIX, add-prefix=PREFIX
+// or regex=REGEXP=>REPLACEMENT.
+func Parse(s string) (Rule, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return Rule{}, fmt.Errorf("rename: invalid rule %q, must be %s=PREFIX, %s=PREFIX or %s=REGEXP%sREPLACEMENT", s, StripPrefix, AddPrefix, Regex, regexSeparator)
+	}
+	rule := Rule{Kind: s[:i]}
+	arg := s[i+1:]
+
+	switch rule.Kind {
+	case StripPrefix, AddPrefix:
+		if arg == "" {
+			return rule, fmt.Errorf("rename: %s requires a prefix", rule.Kind)
+		}
+		rule.Prefix = arg
+	case Regex:
+		j := strings.Index(arg, regexSeparator)
+		if j < 0 {
+			return rule, fmt.Errorf("rename: regex %q must be REGEXP%sREPLACEMENT", arg, regexSeparator)
+		}
+		re, err := regexp.Compile(arg[:j])
+		if err != nil {
+			return rule, fmt.Errorf("rename: %s", err)
+		}
+		rule.Regexp = re
+		rule.Replacement = arg[j+len(regexSeparator):]
+	default:
+		return rule, fmt.Errorf("rename: unknown rule %q, must be one of %s,%s,%s", rule.Kind, StripPrefix, AddPrefix, Regex)
+	}
+
+	return rule, nil
+}
+
+// Apply returns the key rewritten by the Rule.
+func (r Rule) Apply(key string) string {
+	switch r.Kind {
+	case StripPrefix:
+		return strings.TrimPrefix(key, r.Prefix)
+	case AddPrefix:
+		return r.Prefix + key
+	case Regex:
+		return r.Regexp.ReplaceAllString(key, r.Replacement)
+	}
+	return key
+}
+
+// Rename reads Payloads from the In message bus, rewrites their keys with
+// Rules applied in order, and sends them to the Out message bus.
+// Abort stops the sync when two source keys are renamed to the same
+// target key, otherwise the collisions are reported and Counted.
+// Target keys are mapped to their source keys by their db and keyset.Hash:
+// two target keys of the same db and hash are taken for a collision, aborting the
+// sync with Abort even though nothing was overwritten.
+// Log is where collisions and status messages are printed, os.Stdout by
+// default.
+type Rename struct {
+	Rules []Rule
+	In    message.Bus
+	Out   message.Bus
+	Abort bool
+	Count int
+	Log   io.Writer
+
+	// sources maps the target keys to the hashes of their source keys.
+	sources map[targetKey]uint64
+}
+
+// targetKey is a target key, by its db and hash: keys in different dbs
+// never collide.
+type targetKey struct {
+	db   int32
+	hash uint64
+}
+
+// New creates the Rename struct, to be run between a reader and a writer.
+func New(rules []Rule, in, out message.Bus) *Rename {
+	return &Rename{
+		Rules:   rules,
+		In:      in,
+		Out:     out,
+		Log:     os.Stdout,
+		sources: map[targetKey]uint64{},
+	}
+}
+
+// Key returns the target key of a source key.
+func (r *Rename) Key(key string) string {
+	for _, rule := range r.Rules {
+		key = rule.Apply(key)
+	}
+	return key
+}
+
+// Payload returns p with its key renamed, and the key argument of its
+// command. It returns an error on a collision with Abort.
+func (r *Rename) Payload(p message.Payload) (message.Payload, error) {
+	key := r.Key(p.Key)
+
+	target, source := targetKey{p.Db, keyset.Hash(key)}, keyset.Hash(p.Key)
+	if s, ok := r.sources[target]; !ok {
+		r.sources[target] = source
+	} else if s != source {
+		err := fmt.Errorf("rename: key %s renamed to %s, already written from another source key", p.Key, key)
+		if r.Abort {
+			return p, err
+		}
+		r.Count++
+		fmt.Fprintln(r.Log)
+		fmt.Fprintln(r.Log, err)
+		// Reported once, for the key overwriting the target key.
+		r.sources[target] = source
+	}
+
+	if len(p.Command) > 1 && p.Command[1] == p.Key {
+		p.Command = append([]string(nil), p.Command...)
+		p.Command[1] = key
+	}
+	p.Key = key
+	return p, nil
+}
+
+// Run renames the Payloads of In until it's closed, then closes Out.
+func (r *Rename) Run(ctx context.Context) error {
+	defer close(r.Out)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(r.Log)
+			fmt.Fprintln(r.Log, "rename: exit")
+			return ctx.Err()
+		case p, ok := <-r.In:
+			if !ok {
+				if r.Count > 0 {
+					fmt.Fprintf(r.Log, "rename: %d collisions\n", r.Count)
+				}
+				return nil
+			}
+
+			p, err := r.Payload(p)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				fmt.Fprintln(r.Log)
+				fmt.Fprintln(r.Log, "rename: exit")
+				return ctx.Err()
+			case r.Out <- p:
+			}
+		}
+	}
+}
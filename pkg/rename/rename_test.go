@@ -0,0 +1,92 @@
+package rename
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/domwong/rump/pkg/message"
+)
+
+func rules(t *testing.T, specs ...string) []Rule {
+	var rules []Rule
+	for _, s := range specs {
+		rule, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func TestParse(t *testing.T) {
+	invalid := []string{"app:", "strip-prefix=", "add-prefix=", "regex=^a", "regex=(=>b", "swap=a"}
+	for _, s := range invalid {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%s should be invalid", s)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	r := New(rules(t, "strip-prefix=app1:", `regex=^user:(\d+)$=>u:${1}`, "add-prefix=shared:"), nil, nil)
+	keys := map[string]string{
+		"app1:user:12": "shared:u:12",
+		"app1:cart":    "shared:cart",
+		"app2:user:12": "shared:app2:user:12",
+		"user:x":       "shared:user:x",
+	}
+	for key, expected := range keys {
+		if result := r.Key(key); result != expected {
+			t.Errorf("%s: expected %s, result %s", key, expected, result)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	r := New(rules(t, "strip-prefix=app1:", "strip-prefix=app2:"), nil, nil)
+
+	p, err := r.Payload(message.Payload{Key: "app1:a", Command: []string{"RPUSH", "app1:a", "app1:x"}})
+	if err != nil || p.Key != "a" || !reflect.DeepEqual(p.Command, []string{"RPUSH", "a", "app1:x"}) {
+		t.Errorf("unexpected payload %+v, error %v", p, err)
+	}
+	// The same source key again isn't a collision.
+	if _, err := r.Payload(message.Payload{Key: "app1:a", Value: "v"}); err != nil || r.Count != 0 {
+		t.Errorf("unexpected collision, error %v", err)
+	}
+
+	if _, err := r.Payload(message.Payload{Key: "app2:a", Value: "v"}); err != nil || r.Count != 1 {
+		t.Errorf("collision should be reported, count %d, error %v", r.Count, err)
+	}
+
+	r.Abort = true
+	if _, err := r.Payload(message.Payload{Key: "app1:a", Value: "v"}); err == nil {
+		t.Error("collision should abort")
+	}
+
+	// Keys in different dbs never collide.
+	if _, err := r.Payload(message.Payload{Key: "app1:a", Value: "v", Db: 2}); err != nil {
+		t.Errorf("unexpected collision in another db, error %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	in := make(message.Bus, 2)
+	out := make(message.Bus, 2)
+	in <- message.Payload{Key: "a", Value: "v"}
+	in <- message.Payload{Key: "b", Deleted: true}
+	close(in)
+
+	if err := New(rules(t, "add-prefix=x:"), in, out).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for p := range out {
+		keys = append(keys, p.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"x:a", "x:b"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,82 @@
+// Package resp reads and writes commands in the Redis protocol, RESP.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Command encodes a command as a RESP array of bulk strings.
+func Command(args ...string) []byte {
+	b := make([]byte, 0, 16*len(args)+16)
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, "\r\n"...)
+	for _, arg := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(arg)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, arg...)
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+// ReadLine reads a RESP line, without its CRLF.
+func ReadLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadCommand reads a command, returning its arguments and its size in bytes.
+// Inline commands, such as empty lines, are read as space separated
+// arguments, empty lines being skipped.
+func ReadCommand(r *bufio.Reader) (args []string, n int64, err error) {
+	for len(args) == 0 {
+		line, err := ReadLine(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		n += int64(len(line)) + 2
+
+		if !strings.HasPrefix(line, "*") {
+			args = strings.Fields(line)
+			continue
+		}
+
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("resp: unexpected %q", line)
+		}
+		for i := 0; i < count; i++ {
+			line, err := ReadLine(r)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+			if err != nil || !strings.HasPrefix(line, "$") || size < 0 {
+				return nil, 0, fmt.Errorf("resp: unexpected %q", line)
+			}
+			arg := make([]byte, size+2)
+			if _, err := io.ReadFull(r, arg); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, 0, err
+			}
+			args = append(args, string(arg[:size]))
+			n += int64(len(line)) + 2 + int64(size) + 2
+		}
+	}
+
+	return args, n, nil
+}
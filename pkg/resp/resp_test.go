@@ -0,0 +1,28 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	s := string(Command("set", "k", "a\r\nb")) + "\r\nping\r\n" + "*2\r\n$3\r\ndel\r\n"
+	r := bufio.NewReader(strings.NewReader(s))
+
+	args, n, err := ReadCommand(r)
+	if err != nil || !reflect.DeepEqual(args, []string{"set", "k", "a\r\nb"}) || n != int64(len(Command("set", "k", "a\r\nb"))) {
+		t.Errorf("unexpected command %q %d %v", args, n, err)
+	}
+
+	args, _, err = ReadCommand(r)
+	if err != nil || !reflect.DeepEqual(args, []string{"ping"}) {
+		t.Errorf("unexpected inline command %q %v", args, err)
+	}
+
+	if _, _, err = ReadCommand(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected error %v", err)
+	}
+}
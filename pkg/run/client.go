@@ -0,0 +1,114 @@
+package run
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	rredis "github.com/go-redis/redis/v8"
+
+	"github.com/domwong/rump/pkg/config"
+)
+
+// newSentinelClient creates a Redis client resolving the master through Sentinel:
+// redis+sentinel://[:password@]host:26379[,host:26379]/mastername[/db][?replica=true&sentinel_password=pw]
+// With replica=true the client reads from a random replica.
+// The client re-resolves the master, or replica, on failover.
+func newSentinelClient(res config.Resource, readTimeout time.Duration) (rredis.UniversalClient, error) {
+	u, err := url.Parse(res.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if path[0] == "" {
+		return nil, fmt.Errorf("sentinel master name is required")
+	}
+	db := 0
+	if len(path) > 1 {
+		db, err = strconv.Atoi(path[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sentinel db: %s", path[1])
+		}
+	}
+
+	opts := &rredis.FailoverOptions{
+		MasterName:       path[0],
+		SentinelAddrs:    strings.Split(u.Host, ","),
+		SentinelPassword: u.Query().Get("sentinel_password"),
+		SlaveOnly:        u.Query().Get("replica") == "true",
+		DB:               db,
+		ReadTimeout:      readTimeout,
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if strings.HasPrefix(u.Scheme, "rediss") {
+		opts.TLSConfig = &tls.Config{}
+	}
+
+	return rredis.NewFailoverClient(opts), nil
+}
+
+// newClient creates a Redis client from a Redis Resource.
+// Cluster URIs list the seed nodes separated by commas, and only support DB 0.
+func newClient(res config.Resource, readTimeout time.Duration) (rredis.UniversalClient, error) {
+	if res.IsSentinel {
+		return newSentinelClient(res, readTimeout)
+	}
+
+	if !res.IsCluster {
+		opts, err := rredis.ParseURL(res.URI)
+		if err != nil {
+			return nil, err
+		}
+		opts.ReadTimeout = readTimeout
+		return rredis.NewClient(opts), nil
+	}
+
+	// Parse the first seed node as a plain Redis URI to get auth and TLS.
+	addrs, seed, err := res.ClusterNodes()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := rredis.ParseURL(seed)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DB != 0 {
+		return nil, fmt.Errorf("redis cluster only supports db 0")
+	}
+
+	return rredis.NewClusterClient(&rredis.ClusterOptions{
+		Addrs:       addrs,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		TLSConfig:   opts.TLSConfig,
+		ReadTimeout: readTimeout,
+	}), nil
+}
+
+// newReplicaOptions returns the options of a PSYNC Redis Resource,
+// redis+psync://[[user]:password@]host:6379[/db], the DB to sync.
+func newReplicaOptions(res config.Resource) (*rredis.Options, error) {
+	return rredis.ParseURL(strings.Replace(res.URI, "+psync://", "://", 1))
+}
+
+// failoverTimeout returns how long Sentinel resources wait for a new master,
+// 60s by default or RUMP_FAILOVER_TIMEOUT. Other resources don't wait.
+func failoverTimeout(res config.Resource) (time.Duration, error) {
+	if !res.IsSentinel {
+		return 0, nil
+	}
+
+	if t := os.Getenv("RUMP_FAILOVER_TIMEOUT"); len(t) > 0 {
+		return time.ParseDuration(t)
+	}
+
+	return 60 * time.Second, nil
+}
@@ -0,0 +1,187 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	rredis "github.com/go-redis/redis/v8"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/domwong/rump/pkg/config"
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/redis"
+)
+
+// keyspaceDBs parses the databases with keys of an INFO keyspace reply,
+// with lines like db0:keys=1,expires=0,avg_ttl=0.
+func keyspaceDBs(info string) []int {
+	var dbs []int
+	s := bufio.NewScanner(strings.NewReader(info))
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "db") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		if db, err := strconv.Atoi(line[2:i]); err == nil {
+			dbs = append(dbs, db)
+		}
+	}
+	sort.Ints(dbs)
+	return dbs
+}
+
+// sourceDBs returns the source databases to read: the databases with
+// keys for all databases, the listed databases otherwise.
+func sourceDBs(ctx context.Context, cfg config.Config, readTimeout time.Duration) ([]int, error) {
+	if !cfg.AllDBs {
+		dbs := make([]int, len(cfg.DBs))
+		for i, d := range cfg.DBs {
+			dbs[i] = d.Source
+		}
+		return dbs, nil
+	}
+
+	c, err := newClient(cfg.Source, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	info, err := c.Info(ctx, "keyspace").Result()
+	if err != nil {
+		return nil, fmt.Errorf("source: INFO keyspace: %s", err)
+	}
+	return keyspaceDBs(info), nil
+}
+
+// readDBs reads the source databases in turn, each with its own reader
+// created by newSource, sends their Payloads to ch with their database,
+// then closes ch.
+func readDBs(ctx context.Context, cfg config.Config, readTimeout time.Duration, ch message.Bus, newSource func(c rredis.UniversalClient, bus message.Bus) *redis.Redis) error {
+	defer close(ch)
+
+	dbs, err := sourceDBs(ctx, cfg, readTimeout)
+	if err != nil {
+		return err
+	}
+
+	for _, db := range dbs {
+		c, err := newClient(cfg.Source.WithDB(db), readTimeout)
+		if err != nil {
+			return err
+		}
+
+		bus := make(message.Bus, 100)
+		source := newSource(c, bus)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- source.Read(ctx)
+		}()
+
+		for p := range bus {
+			p.Db = int32(db)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- p:
+			}
+		}
+
+		err = <-errc
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mapDBs sends the Payloads of in to out with their target database,
+// skipping the databases which aren't synced, then closes out.
+func mapDBs(ctx context.Context, cfg config.Config, in, out message.Bus) error {
+	defer close(out)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-in:
+			if !ok {
+				return nil
+			}
+
+			db, ok := cfg.TargetDB(int(p.Db))
+			if !ok {
+				continue
+			}
+			p.Db = int32(db)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- p:
+			}
+		}
+	}
+}
+
+// writeDBs writes the Payloads of in to their databases of the res target,
+// each with its own writer created by newTarget on its first Payload.
+func writeDBs(ctx context.Context, res config.Resource, in message.Bus, newTarget func(c rredis.UniversalClient, bus message.Bus) *redis.Redis) error {
+	g, gctx := errgroup.WithContext(ctx)
+	buses := map[int32]message.Bus{}
+
+	err := func() error {
+		for {
+			select {
+			case <-gctx.Done():
+				return nil
+			case p, ok := <-in:
+				if !ok {
+					return nil
+				}
+
+				bus, ok := buses[p.Db]
+				if !ok {
+					c, err := newClient(res.WithDB(int(p.Db)), 0)
+					if err != nil {
+						return err
+					}
+					bus = make(message.Bus, 100)
+					buses[p.Db] = bus
+					target := newTarget(c, bus)
+					g.Go(func() error {
+						return target.Write(gctx)
+					})
+				}
+
+				select {
+				case <-gctx.Done():
+					return nil
+				case bus <- p:
+				}
+			}
+		}
+	}()
+
+	for _, bus := range buses {
+		close(bus)
+	}
+	if werr := g.Wait(); err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/domwong/rump/pkg/config"
+	"github.com/domwong/rump/pkg/message"
+)
+
+// tee sends each Payload of in to the buses of the targets still writing,
+// their done channel open, then closes the buses.
+func tee(ctx context.Context, in message.Bus, buses []message.Bus, done []chan struct{}, log io.Writer) error {
+	defer func() {
+		for _, bus := range buses {
+			close(bus)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(log)
+			fmt.Fprintln(log, "tee: exit")
+			return ctx.Err()
+		case p, ok := <-in:
+			if !ok {
+				return nil
+			}
+
+			for i, bus := range buses {
+				select {
+				case <-ctx.Done():
+					fmt.Fprintln(log)
+					fmt.Fprintln(log, "tee: exit")
+					return ctx.Err()
+				case <-done[i]:
+				case bus <- p:
+				}
+			}
+		}
+	}
+}
+
+// report prints the result of each target to log, and returns an error
+// if any failed. Targets stopped by an interruption or by the failure
+// of another target aren't counted as failed.
+func report(targets []config.Resource, errs []error, log io.Writer) error {
+	failed := 0
+	for i, res := range targets {
+		switch errs[i] {
+		case nil:
+			fmt.Fprintf(log, "target %s: ok\n", res.Redacted())
+		case context.Canceled:
+			fmt.Fprintf(log, "target %s: stopped\n", res.Redacted())
+		default:
+			failed++
+			fmt.Fprintf(log, "target %s: %s\n", res.Redacted(), errs[i])
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed", failed, len(targets))
+	}
+	return nil
+}
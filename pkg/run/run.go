@@ -4,99 +4,400 @@ package run
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	rredis "github.com/go-redis/redis/v8"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/domwong/rump/pkg/checkpoint"
+	"github.com/domwong/rump/pkg/codec"
 	"github.com/domwong/rump/pkg/config"
 	"github.com/domwong/rump/pkg/file"
+	"github.com/domwong/rump/pkg/keyset"
+	"github.com/domwong/rump/pkg/merge"
 	"github.com/domwong/rump/pkg/message"
 	"github.com/domwong/rump/pkg/redis"
+	"github.com/domwong/rump/pkg/rename"
 	"github.com/domwong/rump/pkg/signal"
+	"github.com/domwong/rump/pkg/transform"
 )
 
-// Exit helper
-func exit(e error) {
-	fmt.Println(e)
+// Exit helper, printing e to log
+func exit(log io.Writer, e error) {
+	fmt.Fprintln(log, e)
 	os.Exit(1)
 }
 
+// redacted returns the URIs of resources without credentials,
+// separated by commas.
+func redacted(resources []config.Resource) string {
+	uris := make([]string, len(resources))
+	for i, res := range resources {
+		uris[i] = res.Redacted()
+	}
+	return strings.Join(uris, ",")
+}
+
 // Run orchestrate the Reader, Writer and Signal handler.
 func Run(cfg config.Config) {
+	// With data written to stdout, progress and status messages are
+	// printed to stderr instead.
+	var log io.Writer = os.Stdout
+	for _, res := range cfg.AllTargets() {
+		if res.IsStdio {
+			log = os.Stderr
+		}
+	}
+
 	// create ErrGroup to manage goroutines
 	ctx, cancel := context.WithCancel(context.Background())
 	g, gctx := errgroup.WithContext(ctx)
 
 	// Start signal handling goroutine
 	g.Go(func() error {
-		return signal.Run(gctx, cancel)
+		return signal.Run(gctx, cancel, log)
 	})
 
 	// Create shared message bus
 	ch := make(message.Bus, 100)
 
-	// Create and run either a Redis or File Source reader.
-	if cfg.Source.IsRedis {
-		opts, err := rredis.ParseURL(cfg.Source.URI)
-		if err != nil {
-			exit(err)
+	// With several databases, then a transform, then renames, the writer
+	// reads the mapped, masked and renamed Payloads of their own buses.
+	out := ch
+	if cfg.MultiDB() {
+		in := out
+		out = make(message.Bus, 100)
+		g.Go(func() error {
+			return mapDBs(gctx, cfg, in, out)
+		})
+	}
+	if cfg.Transform != nil {
+		in := out
+		out = make(message.Bus, 100)
+		t := transform.New(cfg.Transform, in, out)
+		t.Log = log
+		g.Go(func() error {
+			return t.Run(gctx)
+		})
+	}
+	if len(cfg.Rename) > 0 {
+		in := out
+		out = make(message.Bus, 100)
+		r := rename.New(cfg.Rename, in, out)
+		r.Abort = cfg.RenameAbort
+		r.Log = log
+		g.Go(func() error {
+			return r.Run(gctx)
+		})
+	}
+
+	// Create the checkpoint tracking the sync progress, loaded when resuming.
+	var cp *checkpoint.Checkpoint
+	if cfg.Checkpoint != "" {
+		if cfg.Resume {
+			var err error
+			cp, err = checkpoint.Load(cfg.Checkpoint, cfg.Source.Redacted())
+			if err != nil {
+				exit(log, err)
+			}
+			fmt.Fprintf(log, "resume: %d keys already synced\n", cp.Confirmed())
+		} else {
+			cp = checkpoint.New(cfg.Checkpoint, cfg.Source.Redacted())
+		}
+
+		g.Go(func() error {
+			return cp.Run(gctx, cfg.CheckpointInterval)
+		})
+	}
+
+	// Results of the reader and writer, to know if the sync completed.
+	// readDone is closed once readErr is set.
+	var readErr, writeErr error
+	readDone := make(chan struct{})
+
+	// read creates either a Redis replica, Redis or File Source reader of bus.
+	read := func(res config.Resource, bus message.Bus) func(context.Context) error {
+		if res.IsPSync {
+			opts, err := newReplicaOptions(res)
+			if err != nil {
+				exit(log, err)
+			}
+
+			source := redis.NewReplica(opts, bus, cfg.Silent, cfg.TTL)
+			source.Log = log
+			return source.Read
+		}
+
+		if !res.IsRedis {
+			source := file.New(res.URI, bus, cfg.Silent, cfg.TTL)
+			if res.Format != "" {
+				source.Format = res.Format
+			}
+			source.Filter = cfg.Filter
+			source.DB = cfg.RDBDB
+			source.AllDBs = cfg.MultiDB()
+			source.Key = cfg.EncryptionKey
+			source.Log = log
+			return source.Read
 		}
+
+		readTimeout := 60 * time.Second
 		if t := os.Getenv("RUMP_READ_TIMEOUT"); len(t) > 0 {
 			d, err := time.ParseDuration(t)
 			if err != nil {
-				exit(err)
+				exit(log, err)
 			}
-			opts.ReadTimeout = d
-		} else {
-			opts.ReadTimeout = 60 * time.Second
+			readTimeout = d
 		}
 
-		c := rredis.NewClient(opts)
+		timeout, err := failoverTimeout(res)
+		if err != nil {
+			exit(log, err)
+		}
 
-		source := redis.New(c, ch, cfg.Silent, cfg.TTL)
+		newSource := func(c rredis.UniversalClient, bus message.Bus) *redis.Redis {
+			source := redis.New(c, bus, cfg.Silent, cfg.TTL)
+			source.FailoverTimeout = timeout
+			source.Filter = cfg.Filter
+			if cfg.ReadBatch > 0 {
+				source.Batch = cfg.ReadBatch
+			}
+			if cfg.ReadDepth > 0 {
+				source.Depth = cfg.ReadDepth
+			}
+			source.Checkpoint = cp
+			source.Follow = cfg.Follow
+			source.Logical = cfg.Logical
+			source.AbsTTL = cfg.AbsTTL
+			source.Log = log
+			return source
+		}
 
+		if cfg.MultiDB() {
+			return func(ctx context.Context) error {
+				return readDBs(ctx, cfg, readTimeout, bus, newSource)
+			}
+		}
+
+		c, err := newClient(res, readTimeout)
+		if err != nil {
+			exit(log, err)
+		}
+		return newSource(c, bus).Read
+	}
+
+	// Run the reader of a single source, or merge several sources,
+	// each reading its own bus, into the shared bus.
+	sources := cfg.AllSources()
+	if len(sources) == 1 {
+		r := read(cfg.Source, ch)
 		g.Go(func() error {
-			return source.Read(gctx)
+			defer close(readDone)
+			readErr = r(gctx)
+			return readErr
 		})
 	} else {
-		source := file.New(cfg.Source.URI, ch, cfg.Silent, cfg.TTL)
+		buses := make([]message.Bus, len(sources))
+		errs := make([]error, len(sources))
+		var wg sync.WaitGroup
+		for i, res := range sources {
+			i := i
+			buses[i] = make(message.Bus, 100)
+			r := read(res, buses[i])
+
+			wg.Add(1)
+			g.Go(func() error {
+				defer wg.Done()
+				errs[i] = r(gctx)
+				return errs[i]
+			})
+		}
 
 		g.Go(func() error {
-			return source.Read(gctx)
+			defer close(readDone)
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					readErr = err
+					break
+				}
+			}
+			return nil
+		})
+
+		m := merge.New(cfg.Conflict, buses, ch)
+		m.Log = log
+		g.Go(func() error {
+			return m.Run(gctx)
 		})
 	}
 
-	// Create and run either a Redis or File Target writer.
-	if cfg.Target.IsRedis {
-		opts, err := rredis.ParseURL(cfg.Target.URI)
+	// write creates either a Redis or File Target writer of bus.
+	write := func(res config.Resource, bus message.Bus) func(context.Context) error {
+		if !res.IsRedis {
+			target := file.New(res.URI, bus, cfg.Silent, cfg.TTL)
+			if res.Format != "" {
+				target.Format = res.Format
+			}
+			target.DB = cfg.RDBDB
+			target.AllDBs = cfg.MultiDB()
+			target.Source = redacted(cfg.AllSources())
+			target.SourceDB = cfg.Source.DB()
+			if !cfg.Source.IsRedis {
+				target.SourceDB = cfg.RDBDB
+			}
+			// Complete files only once the source was completely read.
+			target.Complete = func() bool {
+				<-readDone
+				return readErr == nil
+			}
+			target.Append = cfg.Resume
+			target.Checkpoint = cp
+			target.Key = cfg.EncryptionKey
+			target.Log = log
+			if res.Codec != "" {
+				c, err := codec.Get(res.Codec)
+				if err != nil {
+					exit(log, err)
+				}
+				target.Codec = c
+			}
+			return target.Write
+		}
+
+		timeout, err := failoverTimeout(res)
 		if err != nil {
-			exit(err)
+			exit(log, err)
 		}
 
-		c := rredis.NewClient(opts)
+		newTarget := func(c rredis.UniversalClient, bus message.Bus) *redis.Redis {
+			target := redis.New(c, bus, cfg.Silent, cfg.TTL)
+			target.FailoverTimeout = timeout
+			// Verify and mirror only walk the target keys selected like the source keys.
+			target.Filter = cfg.Filter
+			if cfg.Writers > 0 {
+				target.Writers = cfg.Writers
+			}
+			// The replication stream must be applied in order.
+			if cfg.Source.IsPSync {
+				target.Writers = 1
+			}
+			if cfg.WriteBatch > 0 {
+				target.WriteBatch = cfg.WriteBatch
+			}
+			target.AbsTTL = cfg.AbsTTL
+			target.Checkpoint = cp
+			target.VerifyReport = cfg.VerifyReport
+			target.TTLWindow = cfg.TTLWindow
+			if cfg.Mirror {
+				target.Seen = keyset.New()
+				target.MirrorDryRun = cfg.MirrorDryRun
+				target.MirrorLimit = cfg.MirrorLimit
+			}
+			target.Log = log
+			return target
+		}
 
-		target := redis.New(c, ch, cfg.Silent, cfg.TTL)
+		if cfg.MultiDB() {
+			return func(ctx context.Context) error {
+				return writeDBs(ctx, res, bus, newTarget)
+			}
+		}
 
+		c, err := newClient(res, 0)
+		if err != nil {
+			exit(log, err)
+		}
+		target := newTarget(c, bus)
+
+		return func(ctx context.Context) error {
+			if cfg.Verify {
+				return target.Verify(ctx)
+			}
+
+			err := target.Write(ctx)
+			if err != nil || !cfg.Mirror {
+				return err
+			}
+
+			// Mirror only once all source keys were seen: the keys of a
+			// read failing on some of them are missing from Seen.
+			<-readDone
+			if readErr != nil {
+				fmt.Fprintln(log, "mirror: skipped, the source wasn't completely read")
+				return nil
+			}
+			return target.Mirror(ctx)
+		}
+	}
+
+	// Run the writer of a single target, or fan out to several targets,
+	// each writing its own bus.
+	targets := cfg.AllTargets()
+	errs := make([]error, len(targets))
+	if len(targets) == 1 {
+		w := write(cfg.Target, out)
 		g.Go(func() error {
 			defer cancel()
-			return target.Write(gctx)
+			writeErr = w(gctx)
+			return writeErr
 		})
 	} else {
-		target := file.New(cfg.Target.URI, ch, cfg.Silent, cfg.TTL)
+		buses := make([]message.Bus, len(targets))
+		done := make([]chan struct{}, len(targets))
+		var wg sync.WaitGroup
+		for i, res := range targets {
+			i := i
+			buses[i] = make(message.Bus, 100)
+			done[i] = make(chan struct{})
+			w := write(res, buses[i])
+
+			wg.Add(1)
+			g.Go(func() error {
+				defer wg.Done()
+				defer close(done[i])
+				errs[i] = w(gctx)
+				// Without abort, the other targets keep going.
+				if !cfg.TargetAbort && errs[i] != context.Canceled {
+					return nil
+				}
+				return errs[i]
+			})
+		}
 
 		g.Go(func() error {
-			defer cancel()
-			return target.Write(gctx)
+			return tee(gctx, out, buses, done, log)
+		})
+		g.Go(func() error {
+			wg.Wait()
+			cancel()
+			return nil
 		})
 	}
 
 	// Block and wait for goroutines
 	err := g.Wait()
+	if len(targets) > 1 {
+		writeErr = report(targets, errs, log)
+		if writeErr != nil && (err == nil || err == context.Canceled) {
+			err = writeErr
+		}
+	}
+
+	// Keep the checkpoint of an interrupted sync, to resume it.
+	if readErr == nil && writeErr == nil {
+		cp.Remove()
+	} else if err := cp.Save(); err != nil {
+		fmt.Fprintln(log, err)
+	}
+
 	if err != nil && err != context.Canceled {
-		exit(err)
+		exit(log, err)
 	} else {
-		fmt.Println("done")
+		fmt.Fprintln(log, "done")
 	}
 }
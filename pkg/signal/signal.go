@@ -5,23 +5,24 @@ package signal
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-// Run will be run in an ErrGroup supervisor.
-func Run(ctx context.Context, cancel context.CancelFunc) error {
+// Run will be run in an ErrGroup supervisor, printing to log.
+func Run(ctx context.Context, cancel context.CancelFunc, log io.Writer) error {
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
 	case sig := <-signalChannel:
-		fmt.Println("signal: ", sig)
+		fmt.Fprintln(log, "signal: ", sig)
 		cancel()
 	case <-ctx.Done():
-		fmt.Println("")
-		fmt.Println("signal: exit")
+		fmt.Fprintln(log)
+		fmt.Fprintln(log, "signal: exit")
 		return ctx.Err()
 	}
 
@@ -0,0 +1,212 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/domwong/rump/pkg/filter"
+)
+
+// Actions of Rules on the selected data.
+const (
+	// Hash replaces data with the hex HMAC-SHA256 of the Rules Salt.
+	Hash = "hash"
+	// Redact replaces data with Redacted.
+	Redact = "redact"
+	// Replace replaces data with the Rule With template, its {hash}
+	// placeholders replaced by the first hashSize digits of the hash of
+	// the data, for consistent fakes: the same data always gets the same fake.
+	Replace = "replace"
+)
+
+// Redacted is the value of redacted data.
+const Redacted = "[REDACTED]"
+
+// hashSize is the number of hex digits of the hash in fakes.
+const hashSize = 12
+
+// Rule transforms the data selected in the values of the Keys matching
+// a Redis glob-style pattern: the values of the hash Fields matching
+// glob-style patterns, the JSON paths inside string values, and the
+// list, set and sorted set Members. JSON paths are dot separated object
+// keys and array indexes, * selecting all of them.
+// Action is Hash, Redact or Replace, With the template of fakes.
+type Rule struct {
+	Keys    string   `json:"keys"`
+	Fields  []string `json:"fields,omitempty"`
+	JSON    []string `json:"json,omitempty"`
+	Members bool     `json:"members,omitempty"`
+	Action  string   `json:"action"`
+	With    string   `json:"with,omitempty"`
+}
+
+// Rules are the Rules applied, in order, to the values of matching keys.
+// Salt keys the hashes, so that they can't be reversed by hashing
+// guesses without it.
+type Rules struct {
+	Salt  string `json:"salt"`
+	Rules []Rule `json:"rules"`
+}
+
+// Load reads Rules from a JSON file.
+func Load(path string) (*Rules, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	rules := &Rules{}
+	if err := dec.Decode(rules); err != nil {
+		return nil, fmt.Errorf("transform: %s: %s", path, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("transform: %s: %s", path, err)
+	}
+
+	return rules, nil
+}
+
+// Validate makes sure each Rule selects keys and data, with a known Action.
+func (r *Rules) Validate() error {
+	if len(r.Rules) == 0 {
+		return fmt.Errorf("no rules")
+	}
+	for i, rule := range r.Rules {
+		switch {
+		case rule.Keys == "":
+			return fmt.Errorf("rule %d: keys is required", i)
+		case len(rule.Fields) == 0 && len(rule.JSON) == 0 && !rule.Members:
+			return fmt.Errorf("rule %d: fields, json or members is required", i)
+		case rule.Action != Hash && rule.Action != Redact && rule.Action != Replace:
+			return fmt.Errorf("rule %d: unknown action %q, must be one of %s,%s,%s", i, rule.Action, Hash, Redact, Replace)
+		case rule.Action == Replace && rule.With == "":
+			return fmt.Errorf("rule %d: replace requires with", i)
+		case rule.Action != Replace && rule.With != "":
+			return fmt.Errorf("rule %d: with requires replace", i)
+		}
+	}
+
+	return nil
+}
+
+// match returns the Rules of key.
+func (r *Rules) match(key string) []Rule {
+	var rules []Rule
+	for _, rule := range r.Rules {
+		if filter.Match(rule.Keys, key) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// mask applies the Action of rule to s.
+func (r *Rules) mask(rule Rule, s string) string {
+	switch rule.Action {
+	case Redact:
+		return Redacted
+	case Replace:
+		return strings.Replace(rule.With, "{hash}", r.hash(s)[:hashSize], -1)
+	}
+	return r.hash(s)
+}
+
+func (r *Rules) hash(s string) string {
+	h := hmac.New(sha256.New, []byte(r.Salt))
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// field reports whether rule selects a hash field.
+func (rule Rule) field(name string) bool {
+	for _, p := range rule.Fields {
+		if filter.Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskJSON applies rule to the JSON paths of s, a JSON document.
+// It reports whether a path matched, and returns an error if s isn't JSON:
+// its data can't be selected, so it can't be masked.
+// Masked numbers, booleans and nulls become strings, as do masked objects
+// and arrays, masked as a whole.
+func (r *Rules) maskJSON(rule Rule, s string) (string, bool, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return s, false, errors.New("value isn't JSON, can't be masked")
+	}
+
+	changed := false
+	for _, path := range rule.JSON {
+		doc = r.walk(rule, doc, strings.Split(path, "."), &changed)
+	}
+	if !changed {
+		return s, false, nil
+	}
+
+	b, err := encodeJSON(doc)
+	if err != nil {
+		return s, false, err
+	}
+	return b, true, nil
+}
+
+// encodeJSON encodes doc without escaping HTML, nor a trailing newline.
+func encodeJSON(doc interface{}) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// walk masks the nodes of doc at path.
+func (r *Rules) walk(rule Rule, doc interface{}, path []string, changed *bool) interface{} {
+	if len(path) == 0 {
+		*changed = true
+		switch v := doc.(type) {
+		case map[string]interface{}, []interface{}:
+			// Objects and arrays are masked whole, as their JSON encoding.
+			s, _ := encodeJSON(v)
+			return r.mask(rule, s)
+		case string:
+			return r.mask(rule, v)
+		case nil:
+			return r.mask(rule, "null")
+		default:
+			return r.mask(rule, fmt.Sprint(v))
+		}
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		for k, v := range node {
+			if path[0] == "*" || path[0] == k {
+				node[k] = r.walk(rule, v, path[1:], changed)
+			}
+		}
+	case []interface{}:
+		for i, v := range node {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				node[i] = r.walk(rule, v, path[1:], changed)
+			}
+		}
+	}
+	return doc
+}
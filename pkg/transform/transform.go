@@ -0,0 +1,283 @@
+// Package transform masks personal data on its way from the source to the
+// target, rewriting the values of the keys matching Rules.
+// DUMP payloads are decoded, masked and re-encoded, the command Payloads
+// of logical copies and imported files are masked in place.
+// Data which can't be masked, like streams, modules and the commands
+// without a masked form, fails the transform instead of leaking.
+package transform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+)
+
+// Transform reads Payloads from the In message bus, masks their values
+// following Rules, and sends them to the Out message bus.
+// Log is where status messages are printed, os.Stdout by default.
+type Transform struct {
+	Rules *Rules
+	In    message.Bus
+	Out   message.Bus
+	Log   io.Writer
+}
+
+// New creates the Transform struct, to be run between a reader and a writer.
+func New(rules *Rules, in, out message.Bus) *Transform {
+	return &Transform{
+		Rules: rules,
+		In:    in,
+		Out:   out,
+		Log:   os.Stdout,
+	}
+}
+
+// Run transforms the Payloads of In until it's closed, then closes Out.
+func (t *Transform) Run(ctx context.Context) error {
+	defer close(t.Out)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(t.Log)
+			fmt.Fprintln(t.Log, "transform: exit")
+			return ctx.Err()
+		case p, ok := <-t.In:
+			if !ok {
+				return nil
+			}
+
+			p, err := t.Payload(p)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				fmt.Fprintln(t.Log)
+				fmt.Fprintln(t.Log, "transform: exit")
+				return ctx.Err()
+			case t.Out <- p:
+			}
+		}
+	}
+}
+
+// Payload returns p with its value masked by the Rules of its key, or its
+// command masked by the Rules of the keys it names.
+// Values and commands of matching keys which can't be masked, like
+// streams, modules, MSET or APPEND, return an error.
+func (t *Transform) Payload(p message.Payload) (message.Payload, error) {
+	if p.Deleted {
+		return p, nil
+	}
+
+	if len(p.Command) > 0 {
+		cmd, err := t.Rules.command(p.Command)
+		if err != nil {
+			return p, fmt.Errorf("transform: %s", err)
+		}
+		p.Command = cmd
+		return p, nil
+	}
+
+	rules := t.Rules.match(p.Key)
+	if len(rules) == 0 {
+		return p, nil
+	}
+
+	v, err := rdb.Decode(p.Value)
+	if err != nil {
+		return p, fmt.Errorf("transform: key %s: %s", p.Key, err)
+	}
+	// Streams can't be re-encoded, so their fields can't be masked.
+	if v.Type == "stream" {
+		return p, fmt.Errorf("transform: key %s: streams can't be masked", p.Key)
+	}
+	changed, err := t.Rules.value(rules, v)
+	if err != nil {
+		return p, fmt.Errorf("transform: key %s: %s", p.Key, err)
+	}
+	if !changed {
+		return p, nil
+	}
+
+	// Re-encoded with the RDB version of the source, accepted by the target.
+	if p.Value, err = v.Dump(rdb.DumpVersion(p.Value)); err != nil {
+		return p, fmt.Errorf("transform: key %s: %s", p.Key, err)
+	}
+	return p, nil
+}
+
+// value masks v with rules, and reports whether it changed.
+func (r *Rules) value(rules []Rule, v *rdb.Value) (bool, error) {
+	changed := false
+	for _, rule := range rules {
+		switch v.Type {
+		case "string":
+			if len(rule.JSON) == 0 {
+				continue
+			}
+			s, ok, err := r.maskJSON(rule, v.String)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				v.String = s
+				changed = true
+			}
+		case "list", "set":
+			if !rule.Members {
+				continue
+			}
+			for i, e := range v.Elements {
+				v.Elements[i] = r.mask(rule, e)
+				changed = true
+			}
+		case "zset":
+			if !rule.Members {
+				continue
+			}
+			// Members masked alike are merged, keeping the first score.
+			seen := make(map[string]bool, len(v.ZSet))
+			members := v.ZSet[:0]
+			for _, m := range v.ZSet {
+				m.Member = r.mask(rule, m.Member)
+				if !seen[m.Member] {
+					seen[m.Member] = true
+					members = append(members, m)
+				}
+			}
+			v.ZSet = members
+			changed = true
+		case "hash":
+			for field, value := range v.Hash {
+				if rule.field(field) {
+					v.Hash[field] = r.mask(rule, value)
+					changed = true
+				}
+			}
+		}
+	}
+	return changed, nil
+}
+
+// Kinds of the data arguments of commands.
+const (
+	argValue = iota
+	argMembers
+	argFields
+	argScores
+)
+
+// commandArgs maps the commands writing data to a single key, its first
+// argument, to the kind and index of their first data argument: a string
+// value, list or set members, hash field and value pairs, or sorted set
+// score and member pairs.
+var commandArgs = map[string]struct{ kind, start int }{
+	"SET":    {argValue, 2},
+	"SETNX":  {argValue, 2},
+	"GETSET": {argValue, 2},
+	"SETEX":  {argValue, 3},
+	"PSETEX": {argValue, 3},
+	"RPUSH":  {argMembers, 2},
+	"LPUSH":  {argMembers, 2},
+	"RPUSHX": {argMembers, 2},
+	"LPUSHX": {argMembers, 2},
+	"LSET":   {argMembers, 3},
+	"SADD":   {argMembers, 2},
+	"HSET":   {argFields, 2},
+	"HMSET":  {argFields, 2},
+	"HSETNX": {argFields, 2},
+	"ZADD":   {argScores, 2},
+}
+
+// dataless lists the commands writing no data, safe on any key.
+var dataless = map[string]bool{
+	"DEL":       true,
+	"UNLINK":    true,
+	"EXPIRE":    true,
+	"PEXPIRE":   true,
+	"EXPIREAT":  true,
+	"PEXPIREAT": true,
+	"PERSIST":   true,
+	"SELECT":    true,
+	"FLUSHDB":   true,
+	"FLUSHALL":  true,
+	"MULTI":     true,
+	"EXEC":      true,
+	"PING":      true,
+}
+
+// zaddFlags are the flags of ZADD before its score and member pairs.
+var zaddFlags = map[string]bool{"NX": true, "XX": true, "GT": true, "LT": true, "CH": true, "INCR": true}
+
+// command returns cmd with its data arguments masked by the Rules of its
+// key. Other commands, whose keys aren't known, return an error when any
+// of their arguments matches Rules: they can't be masked.
+func (r *Rules) command(cmd []string) ([]string, error) {
+	name := strings.ToUpper(cmd[0])
+	if dataless[name] {
+		return cmd, nil
+	}
+
+	args, ok := commandArgs[name]
+	if !ok {
+		for _, arg := range cmd[1:] {
+			if len(r.match(arg)) > 0 {
+				return nil, fmt.Errorf("%s on key %s can't be masked", name, arg)
+			}
+		}
+		return cmd, nil
+	}
+	if len(cmd) <= args.start {
+		return cmd, nil
+	}
+	rules := r.match(cmd[1])
+	if len(rules) == 0 {
+		return cmd, nil
+	}
+
+	masked := append([]string(nil), cmd...)
+	for _, rule := range rules {
+		switch args.kind {
+		case argValue:
+			if len(rule.JSON) > 0 {
+				var err error
+				if masked[args.start], _, err = r.maskJSON(rule, masked[args.start]); err != nil {
+					return nil, fmt.Errorf("%s on key %s: %s", name, cmd[1], err)
+				}
+			}
+		case argMembers:
+			if !rule.Members {
+				continue
+			}
+			for i := args.start; i < len(masked); i++ {
+				masked[i] = r.mask(rule, masked[i])
+			}
+		case argFields:
+			for i := args.start; i+1 < len(masked); i += 2 {
+				if rule.field(masked[i]) {
+					masked[i+1] = r.mask(rule, masked[i+1])
+				}
+			}
+		case argScores:
+			if !rule.Members {
+				continue
+			}
+			i := args.start
+			for i < len(masked) && zaddFlags[strings.ToUpper(masked[i])] {
+				i++
+			}
+			for i++; i < len(masked); i += 2 {
+				masked[i] = r.mask(rule, masked[i])
+			}
+		}
+	}
+	return masked, nil
+}
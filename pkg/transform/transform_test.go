@@ -0,0 +1,183 @@
+package transform
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/domwong/rump/pkg/message"
+	"github.com/domwong/rump/pkg/rdb"
+)
+
+var rules = &Rules{
+	Salt: "salt",
+	Rules: []Rule{
+		{Keys: "user:*", Fields: []string{"email", "phone*"}, Action: Replace, With: "user-{hash}@example.com"},
+		{Keys: "profile:*", JSON: []string{"contact.email", "addresses.*.street"}, Action: Redact},
+		{Keys: "emails", Members: true, Action: Hash},
+	},
+}
+
+func dump(t *testing.T, v *rdb.Value) string {
+	d, err := v.Dump(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func decode(t *testing.T, p message.Payload) *rdb.Value {
+	v, err := rdb.Decode(p.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestLoad(t *testing.T) {
+	path := os.TempDir() + "/transform.json"
+	defer os.Remove(path)
+
+	ioutil.WriteFile(path, []byte(`{"salt":"s","rules":[{"keys":"user:*","fields":["email"],"action":"hash"}]}`), 0666)
+	if r, err := Load(path); err != nil || r.Rules[0].Fields[0] != "email" {
+		t.Errorf("unexpected rules %+v, error %v", r, err)
+	}
+
+	invalid := []string{
+		`{"rules":[]}`,
+		`{"rules":[{"fields":["email"],"action":"hash"}]}`,
+		`{"rules":[{"keys":"*","action":"hash"}]}`,
+		`{"rules":[{"keys":"*","members":true,"action":"shuffle"}]}`,
+		`{"rules":[{"keys":"*","members":true,"action":"replace"}]}`,
+		`{"rules":[{"keys":"*","members":true,"action":"hash","with":"x"}]}`,
+		`{"rules":[{"keys":"*","members":true,"action":"hash","unknown":1}]}`,
+	}
+	for _, s := range invalid {
+		ioutil.WriteFile(path, []byte(s), 0666)
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s should be invalid", s)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	tr := New(rules, nil, nil)
+
+	hash := &rdb.Value{Type: "hash", Hash: map[string]string{"email": "a@b.c", "phone2": "123", "name": "A"}}
+	p, err := tr.Payload(message.Payload{Key: "user:1", Value: dump(t, hash), Ttl: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := decode(t, p)
+	fake := v.Hash["email"]
+	if !strings.HasPrefix(fake, "user-") || !strings.HasSuffix(fake, "@example.com") || fake == v.Hash["phone2"] || v.Hash["name"] != "A" || p.Ttl != "10" {
+		t.Errorf("unexpected hash %v", v.Hash)
+	}
+
+	// Fakes are consistent across keys and payload kinds.
+	p, _ = tr.Payload(message.Payload{Key: "user:2", Command: []string{"HSET", "user:2", "email", "a@b.c", "name", "B"}})
+	if !reflect.DeepEqual(p.Command, []string{"HSET", "user:2", "email", fake, "name", "B"}) {
+		t.Errorf("unexpected command %q", p.Command)
+	}
+
+	str := &rdb.Value{Type: "string", String: `{"contact":{"email":"a@b.c","id":1},"addresses":[{"street":"Main St"},{"street":"2nd"}]}`}
+	p, _ = tr.Payload(message.Payload{Key: "profile:1", Value: dump(t, str)})
+	expected := `{"addresses":[{"street":"[REDACTED]"},{"street":"[REDACTED]"}],"contact":{"email":"[REDACTED]","id":1}}`
+	if v := decode(t, p); v.String != expected {
+		t.Errorf("unexpected json %s", v.String)
+	}
+
+	// Objects and arrays selected by paths are masked whole.
+	str = &rdb.Value{Type: "string", String: `{"contact":{"email":["a@b.c"]},"addresses":[{"street":{"line":"Main St"}}]}`}
+	p, _ = tr.Payload(message.Payload{Key: "profile:3", Value: dump(t, str)})
+	expected = `{"addresses":[{"street":"[REDACTED]"}],"contact":{"email":"[REDACTED]"}}`
+	if v := decode(t, p); v.String != expected {
+		t.Errorf("unexpected json %s", v.String)
+	}
+
+	// Strings which aren't JSON can't be masked, nor leak.
+	str = &rdb.Value{Type: "string", String: "plain"}
+	if _, err := tr.Payload(message.Payload{Key: "profile:2", Value: dump(t, str)}); err == nil {
+		t.Error("expected error masking a string which isn't JSON")
+	}
+	if _, err := tr.Payload(message.Payload{Key: "profile:2", Command: []string{"SET", "profile:2", "plain"}}); err == nil {
+		t.Error("expected error masking a SET of a string which isn't JSON")
+	}
+
+	set := &rdb.Value{Type: "set", Elements: []string{"a@b.c"}}
+	p, _ = tr.Payload(message.Payload{Key: "emails", Value: dump(t, set)})
+	if v := decode(t, p); v.Elements[0] != rules.hash("a@b.c") || len(v.Elements[0]) != 64 {
+		t.Errorf("unexpected set %q", v.Elements)
+	}
+	p, _ = tr.Payload(message.Payload{Key: "emails", Command: []string{"SADD", "emails", "a@b.c"}})
+	if p.Command[2] != rules.hash("a@b.c") {
+		t.Errorf("unexpected command %q", p.Command)
+	}
+
+	zset := &rdb.Value{Type: "zset", ZSet: []rdb.Member{{Member: "a@b.c", Score: 1}}}
+	p, _ = tr.Payload(message.Payload{Key: "emails", Value: dump(t, zset)})
+	if v := decode(t, p); v.ZSet[0].Member != rules.hash("a@b.c") || v.ZSet[0].Score != 1 {
+		t.Errorf("unexpected zset %v", v.ZSet)
+	}
+	p, _ = tr.Payload(message.Payload{Key: "emails", Command: []string{"ZADD", "emails", "NX", "1", "a@b.c"}})
+	if !reflect.DeepEqual(p.Command, []string{"ZADD", "emails", "NX", "1", rules.hash("a@b.c")}) {
+		t.Errorf("unexpected command %q", p.Command)
+	}
+
+	// Data of matching keys which can't be masked fails, whatever the
+	// position of the key.
+	stream := rdb.Dump(rdb.TypeStreamListpacks, []byte("\x00"), 9)
+	if _, err := tr.Payload(message.Payload{Key: "user:3", Value: stream}); err == nil {
+		t.Error("streams shouldn't be masked")
+	}
+	unmasked := [][]string{
+		{"MSET", "other", "v", "user:3", "a@b.c"},
+		{"APPEND", "user:3", "a@b.c"},
+		{"XADD", "user:3", "*", "email", "a@b.c"},
+		{"RESTORE", "user:3", "0", "payload"},
+	}
+	for _, cmd := range unmasked {
+		if _, err := tr.Payload(message.Payload{Key: cmd[1], Command: cmd}); err == nil {
+			t.Errorf("%q shouldn't be masked", cmd)
+		}
+	}
+	for _, cmd := range [][]string{{"MSET", "a", "1", "b", "2"}, {"DEL", "user:3", "emails"}} {
+		if p, err := tr.Payload(message.Payload{Key: cmd[1], Command: cmd}); err != nil || !reflect.DeepEqual(p.Command, cmd) {
+			t.Errorf("unexpected command %q, error %v", p.Command, err)
+		}
+	}
+
+	// Other keys and deletions are unchanged.
+	other := message.Payload{Key: "other", Value: dump(t, hash)}
+	if p, _ := tr.Payload(other); !reflect.DeepEqual(p, other) {
+		t.Errorf("unexpected payload %+v", p)
+	}
+	deleted := message.Payload{Key: "user:1", Deleted: true}
+	if p, _ := tr.Payload(deleted); !reflect.DeepEqual(p, deleted) {
+		t.Errorf("unexpected payload %+v", p)
+	}
+}
+
+func TestRun(t *testing.T) {
+	in := make(message.Bus, 2)
+	out := make(message.Bus, 2)
+	in <- message.Payload{Key: "emails", Command: []string{"RPUSH", "emails", "a", "b"}}
+	in <- message.Payload{Key: "emails", Command: []string{"PEXPIRE", "emails", "100"}}
+	close(in)
+
+	if err := New(rules, in, out).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var cmds [][]string
+	for p := range out {
+		cmds = append(cmds, p.Command)
+	}
+	expected := [][]string{{"RPUSH", "emails", rules.hash("a"), rules.hash("b")}, {"PEXPIRE", "emails", "100"}}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("expected %q, result %q", expected, cmds)
+	}
+}